@@ -0,0 +1,124 @@
+package v1
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// RenderTemplate substitutes variables into text using Go template syntax.
+// Text without template actions is returned unchanged; referencing a variable
+// that isn't defined is an error, also when no variables are defined.
+func RenderTemplate(name, text string, vars map[string]string) (string, error) {
+	if !strings.Contains(text, "{{") {
+		return text, nil
+	}
+
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid template: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, vars); err != nil {
+		return "", fmt.Errorf("failed to execute template: %w", err)
+	}
+
+	return buf.String(), nil
+}
+
+// KeyTemplateData is the data available to Storage.KeyTemplate
+type KeyTemplateData struct {
+	Tenant     string
+	Name       string
+	Namespace  string
+	Index      int
+	BatchIndex int
+	Format     string
+	ColorMode  string
+	Date       time.Time
+}
+
+// RenderKeyTemplate renders an object key template. Templates reference
+// {{.tenant}}, {{.name}}, {{.namespace}}, {{.index}}, {{.batchIndex}},
+// {{.format}}, {{.colorMode}} and {{.date}} (YYYY/MM/DD). Keys may not escape the bucket
+// with ".." segments or a leading slash.
+func RenderKeyTemplate(text string, data KeyTemplateData) (string, error) {
+	tmpl, err := template.New("keyTemplate").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid key template: %w", err)
+	}
+
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, map[string]interface{}{
+		"tenant":     data.Tenant,
+		"name":       data.Name,
+		"namespace":  data.Namespace,
+		"index":      data.Index,
+		"batchIndex": data.BatchIndex,
+		"format":     data.Format,
+		"colorMode":  data.ColorMode,
+		"date":       data.Date.UTC().Format("2006/01/02"),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to execute key template: %w", err)
+	}
+
+	key := buf.String()
+	if key == "" || strings.HasPrefix(key, "/") {
+		return "", fmt.Errorf("key template rendered an invalid key %q", key)
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == ".." {
+			return "", fmt.Errorf("key template rendered %q, which escapes the key prefix", key)
+		}
+	}
+	return path.Clean(key), nil
+}
+
+// validateKeyTemplate checks that a key template renders and yields a
+// distinct key for every generated file
+func validateKeyTemplate(text string, batch, bothColorModes bool) error {
+	sample := KeyTemplateData{Tenant: "tenant", Name: "name", Namespace: "namespace", Format: "svg", ColorMode: "light", Date: time.Now()}
+	first, err := RenderKeyTemplate(text, sample)
+	if err != nil {
+		return err
+	}
+
+	// distinct renders the template for a variant of the sample and checks
+	// that the key differs from the first one
+	distinct := func(variant KeyTemplateData, msg string) error {
+		second, err := RenderKeyTemplate(text, variant)
+		if err != nil {
+			return err
+		}
+		if second == first {
+			return fmt.Errorf("%s", msg)
+		}
+		return nil
+	}
+
+	variant := sample
+	variant.Index = 1
+	if err := distinct(variant, "key template must reference {{.index}}"); err != nil {
+		return err
+	}
+	if batch {
+		variant = sample
+		variant.BatchIndex = 1
+		if err := distinct(variant, "key template must reference {{.batchIndex}} for batch visuals"); err != nil {
+			return err
+		}
+	}
+	if bothColorModes {
+		variant = sample
+		variant.ColorMode = "dark"
+		if err := distinct(variant, "key template must reference {{.colorMode}} when colorMode is both"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
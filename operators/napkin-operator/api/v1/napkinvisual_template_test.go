@@ -0,0 +1,116 @@
+package v1
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenderTemplate(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		vars    map[string]string
+		want    string
+		wantErr string
+	}{
+		{name: "substitutes variables", text: "Deploy {{.service}} to {{.env}}", vars: map[string]string{"service": "payments", "env": "prod"}, want: "Deploy payments to prod"},
+		{name: "plain text without variables", text: "Deploy payments", want: "Deploy payments"},
+		{name: "no variables defined", text: "Deploy {{.service}}", wantErr: "service"},
+		{name: "empty text", text: "", vars: map[string]string{"service": "payments"}, want: ""},
+		{name: "undefined variable", text: "Deploy {{.service}} to {{.region}}", vars: map[string]string{"service": "payments"}, wantErr: "region"},
+		{name: "invalid syntax", text: "Deploy {{.service", vars: map[string]string{"service": "payments"}, wantErr: "invalid template"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := RenderTemplate("content", tt.text, tt.vars)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %q, %v", tt.wantErr, got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("RenderTemplate: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderKeyTemplate(t *testing.T) {
+	// A fixed clock just after midnight in UTC+10, which is still the
+	// previous day in UTC
+	date := time.Date(2026, 3, 10, 8, 30, 0, 0, time.FixedZone("AEST", 10*60*60))
+	data := KeyTemplateData{Tenant: "acme", Name: "diagram", Namespace: "team-a", Index: 2, BatchIndex: 1, Format: "png", ColorMode: "dark", Date: date}
+
+	tests := []struct {
+		name    string
+		text    string
+		want    string
+		wantErr string
+	}{
+		{name: "date partitioned", text: "{{.date}}/{{.tenant}}/{{.name}}/{{.index}}.{{.format}}", want: "2026/03/09/acme/diagram/2.png"},
+		{name: "all fields", text: "{{.namespace}}/{{.name}}/{{.batchIndex}}/{{.index}}-{{.colorMode}}.{{.format}}", want: "team-a/diagram/1/2-dark.png"},
+		{name: "cleans duplicate slashes", text: "{{.tenant}}//{{.index}}.{{.format}}", want: "acme/2.png"},
+		{name: "parent segment", text: "{{.tenant}}/../{{.index}}", wantErr: "escapes"},
+		{name: "leading slash", text: "/{{.tenant}}/{{.index}}", wantErr: "invalid key"},
+		{name: "empty key", text: "{{if false}}x{{end}}", wantErr: "invalid key"},
+		{name: "unknown field", text: "{{.tenant}}/{{.owner}}", wantErr: "failed to execute"},
+		{name: "invalid syntax", text: "{{.tenant", wantErr: "invalid key template"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := RenderKeyTemplate(tt.text, data)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %q, %v", tt.wantErr, got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("RenderKeyTemplate: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateKeyTemplate(t *testing.T) {
+	tests := []struct {
+		name           string
+		text           string
+		batch          bool
+		bothColorModes bool
+		wantErr        string
+	}{
+		{name: "distinct per index", text: "{{.date}}/{{.name}}/{{.index}}.{{.format}}"},
+		{name: "missing index", text: "{{.name}}.{{.format}}", wantErr: "{{.index}}"},
+		{name: "batch without batch index", text: "{{.name}}/{{.index}}", batch: true, wantErr: "{{.batchIndex}}"},
+		{name: "batch with batch index", text: "{{.name}}/{{.batchIndex}}/{{.index}}", batch: true},
+		{name: "both modes without color mode", text: "{{.name}}/{{.index}}", bothColorModes: true, wantErr: "{{.colorMode}}"},
+		{name: "both modes with color mode", text: "{{.name}}/{{.index}}-{{.colorMode}}", bothColorModes: true},
+		// Only the later sample renders fail, which must not pass as distinct keys
+		{name: "error for second index", text: "{{if .index}}{{.owner}}{{end}}{{.name}}", wantErr: "failed to execute"},
+		{name: "traversal for second index", text: "{{if .index}}../{{end}}{{.name}}", wantErr: "escapes"},
+		{name: "error for batch index", text: "{{.index}}/{{if .batchIndex}}{{.owner}}{{end}}x", batch: true, wantErr: "failed to execute"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateKeyTemplate(tt.text, tt.batch, tt.bothColorModes)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("validateKeyTemplate: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -5,11 +5,23 @@ import (
 )
 
 // NapkinVisualSpec defines the desired state of NapkinVisual
+// +kubebuilder:validation:XValidation:rule="has(self.content) || has(self.contentFrom) || has(self.batch)",message="one of content, contentFrom or batch must be set"
 type NapkinVisualSpec struct {
 	// Content is the text to visualize
 	// +kubebuilder:validation:MinLength=1
 	// +kubebuilder:validation:MaxLength=50000
-	Content string `json:"content"`
+	Content string `json:"content,omitempty"`
+
+	// ContentFrom reads the content from a ConfigMap key in the visual's
+	// namespace instead of Content
+	ContentFrom *ConfigMapKeyRef `json:"contentFrom,omitempty"`
+
+	// Batch is a list of content items, each generated as a separate Napkin
+	// request. When set, Content is ignored.
+	// +kubebuilder:validation:MaxItems=20
+	// +kubebuilder:validation:items:MinLength=1
+	// +kubebuilder:validation:items:MaxLength=50000
+	Batch []string `json:"batch,omitempty"`
 
 	// Format is the output format
 	// +kubebuilder:validation:Enum=svg;png;ppt
@@ -29,17 +41,66 @@ type NapkinVisualSpec struct {
 	// +kubebuilder:default=1
 	Variations int `json:"variations,omitempty"`
 
+	// Width is the output width in pixels (png and ppt). Napkin chooses when unset.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=8192
+	Width int `json:"width,omitempty"`
+
+	// Height is the output height in pixels (png and ppt). Napkin chooses when unset.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=8192
+	Height int `json:"height,omitempty"`
+
+	// DPI is the output resolution (png and ppt). Napkin chooses when unset.
+	// +kubebuilder:validation:Minimum=72
+	// +kubebuilder:validation:Maximum=600
+	DPI int `json:"dpi,omitempty"`
+
 	// Context provides additional context for generation
 	Context string `json:"context,omitempty"`
 
+	// Variables are substituted into Content and Context using Go template
+	// syntax (e.g. {{.name}}). Referencing an undefined variable is an error.
+	Variables map[string]string `json:"variables,omitempty"`
+
 	// TenantId for multi-tenant isolation
 	TenantId string `json:"tenantId,omitempty"`
 
 	// ApiKeySecretRef references a Secret containing the Napkin API key
 	ApiKeySecretRef SecretKeyRef `json:"apiKeySecretRef,omitempty"`
 
+	// ApiKeyFile is a path in the operator pod to a file containing the Napkin API key
+	// (e.g. injected by a Vault sidecar). Used when the Secret cannot be read.
+	// Relative paths are resolved in the operator's --api-key-dir, and paths
+	// outside that directory are rejected.
+	ApiKeyFile string `json:"apiKeyFile,omitempty"`
+
 	// Storage configures where generated visuals are stored
 	Storage NapkinStorageSpec `json:"storage,omitempty"`
+
+	// RegenerateOnChange restarts generation when content or style fields change
+	// after the visual has completed
+	RegenerateOnChange bool `json:"regenerateOnChange,omitempty"`
+
+	// Deduplicate reuses files previously generated from an identical request
+	// instead of calling Napkin. Files are stored under the request hash and
+	// are not deleted with the visual since other visuals may share them.
+	Deduplicate bool `json:"deduplicate,omitempty"`
+
+	// CallbackURL receives a JSON POST when the visual completes or fails
+	// without further automatic retries
+	// +kubebuilder:validation:Pattern=`^https?://`
+	CallbackURL string `json:"callbackUrl,omitempty"`
+
+	// MaxRetries is the number of failures after which the visual is no longer retried
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:default=3
+	MaxRetries *int `json:"maxRetries,omitempty"`
+
+	// RetryDelaySeconds is the delay between a failure and the next retry
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default=300
+	RetryDelaySeconds int `json:"retryDelaySeconds,omitempty"`
 }
 
 // NapkinStyleSpec contains style configuration
@@ -68,6 +129,15 @@ type SecretKeyRef struct {
 	Key string `json:"key,omitempty"`
 }
 
+// ConfigMapKeyRef references a key in a ConfigMap
+type ConfigMapKeyRef struct {
+	// Name is the ConfigMap name
+	Name string `json:"name"`
+
+	// Key is the key within the ConfigMap
+	Key string `json:"key"`
+}
+
 // NapkinStorageSpec configures MinIO storage
 type NapkinStorageSpec struct {
 	// Bucket is the MinIO bucket name
@@ -76,23 +146,54 @@ type NapkinStorageSpec struct {
 
 	// Prefix is the object key prefix
 	Prefix string `json:"prefix,omitempty"`
+
+	// KeyTemplate is a Go template for object keys below Prefix, replacing the
+	// default {tenant}/{name}/{index}.{format} layout. Available fields are
+	// .tenant, .name, .namespace, .index, .batchIndex, .format, .colorMode and .date
+	// (YYYY/MM/DD of the generation start).
+	KeyTemplate string `json:"keyTemplate,omitempty"`
+
+	// BucketPerTenant stores each tenant's visuals in a dedicated bucket named
+	// {bucket}-{tenantId} instead of under a tenant prefix in a shared bucket.
+	// Names that aren't valid bucket names are sanitized and get a short hash
+	// of the original appended.
+	BucketPerTenant bool `json:"bucketPerTenant,omitempty"`
+
+	// ExpireAfterDays expires this visual's objects after the given number of
+	// days. Objects are tagged expire-after-days and matched by a bucket
+	// lifecycle rule, so other objects in the bucket are unaffected.
+	// +kubebuilder:validation:Minimum=1
+	ExpireAfterDays int `json:"expireAfterDays,omitempty"`
 }
 
 // NapkinVisualStatus defines the observed state of NapkinVisual
 type NapkinVisualStatus struct {
 	// Phase is the current phase of the visual generation lifecycle
-	// +kubebuilder:validation:Enum=Pending;Submitted;Processing;Downloading;Uploading;Completed;Failed
+	// +kubebuilder:validation:Enum=Pending;Submitted;Processing;Downloading;Uploading;Completed;Failed;Cancelled
 	Phase string `json:"phase,omitempty"`
 
 	// Conditions represent the latest available observations
 	Conditions []NapkinVisualCondition `json:"conditions,omitempty"`
 
+	// RenderedContent is the Content submitted to Napkin after variable
+	// substitution, truncated to its first 1000 characters
+	RenderedContent string `json:"renderedContent,omitempty"`
+
 	// NapkinRequestId is the Napkin API request ID
 	NapkinRequestId string `json:"napkinRequestId,omitempty"`
 
+	// SubmittedRequest summarizes the request sent to Napkin
+	SubmittedRequest *SubmittedRequestStatus `json:"submittedRequest,omitempty"`
+
+	// BatchItems tracks the per-item state of a batch generation
+	BatchItems []BatchItemStatus `json:"batchItems,omitempty"`
+
 	// GeneratedFiles contains information about generated files
 	GeneratedFiles []GeneratedFileStatus `json:"generatedFiles,omitempty"`
 
+	// PrimaryUrl is the MinIO URL of the first generated file
+	PrimaryUrl string `json:"primaryUrl,omitempty"`
+
 	// StartTime is when processing started
 	StartTime *metav1.Time `json:"startTime,omitempty"`
 
@@ -102,9 +203,24 @@ type NapkinVisualStatus struct {
 	// RetryCount is the number of retries attempted
 	RetryCount int `json:"retryCount,omitempty"`
 
-	// LastError is the last error message
+	// LastError is the last error message, truncated for display
 	LastError string `json:"lastError,omitempty"`
 
+	// RequestHash identifies the Napkin request of a deduplicated visual
+	RequestHash string `json:"requestHash,omitempty"`
+
+	// StorageAttempts counts failed attempts to store the generated files
+	StorageAttempts int `json:"storageAttempts,omitempty"`
+
+	// CallbackAttempts counts attempts to deliver the completion callback
+	CallbackAttempts int `json:"callbackAttempts,omitempty"`
+
+	// SpecHash is a hash of the generation-relevant spec fields at submission time
+	SpecHash string `json:"specHash,omitempty"`
+
+	// LastRetryToken is the last processed value of the napkin.tas.ai/retry annotation
+	LastRetryToken string `json:"lastRetryToken,omitempty"`
+
 	// ObservedGeneration is the generation of the spec that was last processed
 	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
 }
@@ -112,7 +228,7 @@ type NapkinVisualStatus struct {
 // NapkinVisualCondition describes the state of a NapkinVisual at a certain point
 type NapkinVisualCondition struct {
 	// Type of condition
-	// +kubebuilder:validation:Enum=Ready;Submitted;Downloaded;Uploaded
+	// +kubebuilder:validation:Enum=Ready;Submitted;Downloaded;Uploaded;Paused;CallbackDelivered;QuotaExceeded
 	Type string `json:"type"`
 
 	// Status of the condition
@@ -129,11 +245,73 @@ type NapkinVisualCondition struct {
 	Message string `json:"message,omitempty"`
 }
 
+// BatchItemStatus contains the state of a single batch item
+type BatchItemStatus struct {
+	// Index of the item in Spec.Batch
+	Index int `json:"index"`
+
+	// Phase of the item
+	Phase string `json:"phase,omitempty"`
+
+	// NapkinRequestId is the Napkin API request ID for this item
+	NapkinRequestId string `json:"napkinRequestId,omitempty"`
+
+	// Error is the error message if the item failed
+	Error string `json:"error,omitempty"`
+
+	// SubmitAttempts counts failed submissions; the item fails once it
+	// reaches the visual's maxRetries
+	SubmitAttempts int `json:"submitAttempts,omitempty"`
+}
+
+// SubmittedRequestStatus summarizes a Napkin generation request. Content is
+// recorded as a hash and length to keep the status small.
+type SubmittedRequestStatus struct {
+	// ContentSha256 is the hex SHA-256 of the submitted content
+	ContentSha256 string `json:"contentSha256,omitempty"`
+
+	// ContentLength is the length of the submitted content in bytes
+	ContentLength int `json:"contentLength,omitempty"`
+
+	// Format requested
+	Format string `json:"format,omitempty"`
+
+	// StyleId requested
+	StyleId string `json:"styleId,omitempty"`
+
+	// ColorMode requested
+	ColorMode string `json:"colorMode,omitempty"`
+
+	// Orientation requested; empty lets Napkin choose
+	Orientation string `json:"orientation,omitempty"`
+
+	// Language requested
+	Language string `json:"language,omitempty"`
+
+	// Variations requested
+	Variations int `json:"variations,omitempty"`
+
+	// Width requested in pixels
+	Width int `json:"width,omitempty"`
+
+	// Height requested in pixels
+	Height int `json:"height,omitempty"`
+
+	// DPI requested
+	DPI int `json:"dpi,omitempty"`
+
+	// ContextLength is the length of the submitted context in bytes
+	ContextLength int `json:"contextLength,omitempty"`
+}
+
 // GeneratedFileStatus contains information about a generated file
 type GeneratedFileStatus struct {
 	// Index of the file in the generation set
 	Index int `json:"index"`
 
+	// BatchIndex is the Spec.Batch item this file was generated from
+	BatchIndex int `json:"batchIndex,omitempty"`
+
 	// Format of the file
 	Format string `json:"format"`
 
@@ -158,6 +336,10 @@ type GeneratedFileStatus struct {
 //+kubebuilder:printcolumn:name="Format",type="string",JSONPath=".spec.format",description="Output format"
 //+kubebuilder:printcolumn:name="Phase",type="string",JSONPath=".status.phase",description="Current phase"
 //+kubebuilder:printcolumn:name="Files",type="integer",JSONPath=".status.generatedFiles",description="Generated files count"
+//+kubebuilder:printcolumn:name="URL",type="string",JSONPath=".status.primaryUrl",description="Primary file URL",priority=1
+//+kubebuilder:printcolumn:name="Reason",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].reason",description="Ready condition reason"
+//+kubebuilder:printcolumn:name="Retries",type="integer",JSONPath=".status.retryCount",description="Retry count"
+//+kubebuilder:printcolumn:name="Error",type="string",JSONPath=".status.lastError",description="Last error"
 //+kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
 //+kubebuilder:resource:shortName=nv
 
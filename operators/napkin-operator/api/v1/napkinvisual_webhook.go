@@ -0,0 +1,244 @@
+package v1
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"golang.org/x/text/language"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// Defaults applied to NapkinVisual specs
+const (
+	DefaultBucket           = "napkin-visuals"
+	DefaultTenantId         = "default"
+	DefaultFormat           = "svg"
+	DefaultVariations       = 1
+	DefaultColorMode        = "light"
+	DefaultApiKeySecretName = "napkin-api-secret"
+	DefaultApiKeySecretKey  = "NAPKIN_API_KEY"
+)
+
+// MaxContentLength is the longest content accepted, in characters. The CRD
+// enforces it for spec.content and the controller for ConfigMap content.
+const MaxContentLength = 50000
+
+// pptContentWarnLength is the content length above which PPT output is likely
+// to produce more slides than a usable deck
+const pptContentWarnLength = 10000
+
+// Bounds for the requested output size
+const (
+	maxImageDimension = 8192
+	minDPI            = 72
+	maxDPI            = 600
+)
+
+// SetupNapkinVisualWebhookWithManager registers the NapkinVisual webhooks with the manager
+func SetupNapkinVisualWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&NapkinVisual{}).
+		WithDefaulter(&NapkinVisualCustomDefaulter{}).
+		WithValidator(&NapkinVisualCustomValidator{}).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/mutate-napkin-tas-ai-v1-napkinvisual,mutating=true,failurePolicy=fail,sideEffects=None,groups=napkin.tas.ai,resources=napkinvisuals,verbs=create;update,versions=v1,name=mnapkinvisual.kb.io,admissionReviewVersions=v1
+
+// NapkinVisualCustomDefaulter sets default values on NapkinVisual resources
+// +kubebuilder:object:generate=false
+type NapkinVisualCustomDefaulter struct{}
+
+var _ webhook.CustomDefaulter = &NapkinVisualCustomDefaulter{}
+
+// Default implements webhook.CustomDefaulter
+func (d *NapkinVisualCustomDefaulter) Default(ctx context.Context, obj runtime.Object) error {
+	visual, ok := obj.(*NapkinVisual)
+	if !ok {
+		return fmt.Errorf("expected a NapkinVisual but got a %T", obj)
+	}
+
+	visual.SetDefaults()
+	return nil
+}
+
+// SetDefaults fills in unset spec fields with their default values. It is
+// applied at admission time and again by the controller so that objects
+// created without the webhook behave the same way.
+func (v *NapkinVisual) SetDefaults() {
+	spec := &v.Spec
+	if spec.Format == "" {
+		spec.Format = DefaultFormat
+	}
+	if spec.Variations == 0 {
+		spec.Variations = DefaultVariations
+	}
+	if spec.Style.ColorMode == "" {
+		spec.Style.ColorMode = DefaultColorMode
+	}
+	if spec.TenantId == "" {
+		spec.TenantId = DefaultTenantId
+	}
+	if spec.ApiKeySecretRef.Name == "" {
+		spec.ApiKeySecretRef.Name = DefaultApiKeySecretName
+	}
+	if spec.ApiKeySecretRef.Key == "" {
+		spec.ApiKeySecretRef.Key = DefaultApiKeySecretKey
+	}
+	if spec.Storage.Bucket == "" {
+		spec.Storage.Bucket = DefaultBucket
+	}
+}
+
+//+kubebuilder:webhook:path=/validate-napkin-tas-ai-v1-napkinvisual,mutating=false,failurePolicy=fail,sideEffects=None,groups=napkin.tas.ai,resources=napkinvisuals,verbs=create;update,versions=v1,name=vnapkinvisual.kb.io,admissionReviewVersions=v1
+
+// NapkinVisualCustomValidator validates NapkinVisual resources beyond what the
+// CRD schema can express
+// +kubebuilder:object:generate=false
+type NapkinVisualCustomValidator struct{}
+
+var _ webhook.CustomValidator = &NapkinVisualCustomValidator{}
+
+// ValidateCreate implements webhook.CustomValidator
+func (v *NapkinVisualCustomValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	visual, ok := obj.(*NapkinVisual)
+	if !ok {
+		return nil, fmt.Errorf("expected a NapkinVisual but got a %T", obj)
+	}
+	return visual.validateSpec()
+}
+
+// ValidateUpdate implements webhook.CustomValidator
+func (v *NapkinVisualCustomValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	visual, ok := newObj.(*NapkinVisual)
+	if !ok {
+		return nil, fmt.Errorf("expected a NapkinVisual but got a %T", newObj)
+	}
+	old, ok := oldObj.(*NapkinVisual)
+	if !ok {
+		return nil, fmt.Errorf("expected a NapkinVisual but got a %T", oldObj)
+	}
+
+	// Compare with defaults applied, so spelling out a default isn't a change
+	oldDefaulted, newDefaulted := old.DeepCopy(), visual.DeepCopy()
+	oldDefaulted.SetDefaults()
+	newDefaulted.SetDefaults()
+	if err := newDefaulted.validateStorageLocation(oldDefaulted); err != nil {
+		return nil, err
+	}
+
+	// An unchanged spec was accepted before, so metadata and status updates,
+	// such as removing the finalizer, aren't blocked by rules added since
+	if equality.Semantic.DeepEqual(oldDefaulted.Spec, newDefaulted.Spec) {
+		return nil, nil
+	}
+	return visual.validateSpec()
+}
+
+// ValidateDelete implements webhook.CustomValidator
+func (v *NapkinVisualCustomValidator) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+// validateStorageLocation rejects changes to where files are stored once
+// they have been generated, since the uploaded objects would be orphaned
+// under the old location
+func (v *NapkinVisual) validateStorageLocation(old *NapkinVisual) error {
+	if len(old.Status.GeneratedFiles) == 0 {
+		return nil
+	}
+	changed := func(field string, oldValue, newValue any) error {
+		if oldValue != newValue {
+			return fmt.Errorf("spec.%s cannot be changed after files have been generated", field)
+		}
+		return nil
+	}
+	for _, err := range []error{
+		changed("tenantId", old.Spec.TenantId, v.Spec.TenantId),
+		changed("storage.bucket", old.Spec.Storage.Bucket, v.Spec.Storage.Bucket),
+		changed("storage.bucketPerTenant", old.Spec.Storage.BucketPerTenant, v.Spec.Storage.BucketPerTenant),
+		changed("storage.prefix", old.Spec.Storage.Prefix, v.Spec.Storage.Prefix),
+		changed("storage.keyTemplate", old.Spec.Storage.KeyTemplate, v.Spec.Storage.KeyTemplate),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateSpec renders the content templates and checks the language tag
+func (v *NapkinVisual) validateSpec() (admission.Warnings, error) {
+	var warnings admission.Warnings
+	spec := &v.Spec
+
+	if spec.Content != "" && spec.ContentFrom != nil {
+		return warnings, fmt.Errorf("spec.content and spec.contentFrom are mutually exclusive")
+	}
+	if spec.ContentFrom != nil && len(spec.Batch) > 0 {
+		return warnings, fmt.Errorf("spec.contentFrom cannot be combined with spec.batch")
+	}
+
+	names, contents := []string{"content"}, []string{spec.Content}
+	if spec.ContentFrom != nil {
+		// ConfigMap content is only available at reconcile time
+		names, contents = nil, nil
+	}
+	if len(spec.Batch) > 0 {
+		names, contents = nil, spec.Batch
+		for i := range spec.Batch {
+			names = append(names, fmt.Sprintf("batch[%d]", i))
+		}
+	}
+	for i, text := range contents {
+		name := names[i]
+		rendered, err := RenderTemplate(name, text, spec.Variables)
+		if err != nil {
+			return warnings, fmt.Errorf("spec.%s: %w", name, err)
+		}
+		if strings.TrimSpace(rendered) == "" {
+			return warnings, fmt.Errorf("spec.%s is empty after variable substitution", name)
+		}
+		if spec.Format == "ppt" && len(rendered) > pptContentWarnLength {
+			warnings = append(warnings, fmt.Sprintf("spec.%s is %d characters; ppt output may contain too many slides", name, len(rendered)))
+		}
+	}
+
+	if _, err := RenderTemplate("context", spec.Context, spec.Variables); err != nil {
+		return warnings, fmt.Errorf("spec.context: %w", err)
+	}
+
+	for _, dim := range []struct {
+		name  string
+		value int
+	}{{"width", spec.Width}, {"height", spec.Height}} {
+		if dim.value < 0 || dim.value > maxImageDimension {
+			return warnings, fmt.Errorf("spec.%s must be between 1 and %d pixels", dim.name, maxImageDimension)
+		}
+	}
+	if spec.DPI != 0 && (spec.DPI < minDPI || spec.DPI > maxDPI) {
+		return warnings, fmt.Errorf("spec.dpi must be between %d and %d", minDPI, maxDPI)
+	}
+	if spec.Format == "svg" && (spec.Width != 0 || spec.Height != 0 || spec.DPI != 0) {
+		warnings = append(warnings, "spec.width, spec.height and spec.dpi have no effect on svg output, which is scalable")
+	}
+
+	if spec.Storage.KeyTemplate != "" {
+		if err := validateKeyTemplate(spec.Storage.KeyTemplate, len(spec.Batch) > 0, spec.Style.ColorMode == "both"); err != nil {
+			return warnings, fmt.Errorf("spec.storage.keyTemplate: %w", err)
+		}
+	}
+
+	if spec.Language != "" {
+		if _, err := language.Parse(spec.Language); err != nil {
+			return warnings, fmt.Errorf("spec.language %q is not a valid BCP 47 tag: %w", spec.Language, err)
+		}
+	}
+
+	return warnings, nil
+}
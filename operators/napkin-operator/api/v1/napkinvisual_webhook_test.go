@@ -0,0 +1,239 @@
+package v1
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestDefaultAppliesDefaultsOnCreate(t *testing.T) {
+	visual := &NapkinVisual{Spec: NapkinVisualSpec{Content: "Client calls the API"}}
+	if err := (&NapkinVisualCustomDefaulter{}).Default(context.Background(), visual); err != nil {
+		t.Fatalf("Default: %v", err)
+	}
+
+	spec := visual.Spec
+	for _, tt := range []struct {
+		field     string
+		got, want interface{}
+	}{
+		{"format", spec.Format, DefaultFormat},
+		{"variations", spec.Variations, DefaultVariations},
+		{"style.colorMode", spec.Style.ColorMode, DefaultColorMode},
+		{"tenantId", spec.TenantId, DefaultTenantId},
+		{"apiKeySecretRef.name", spec.ApiKeySecretRef.Name, DefaultApiKeySecretName},
+		{"apiKeySecretRef.key", spec.ApiKeySecretRef.Key, DefaultApiKeySecretKey},
+		{"storage.bucket", spec.Storage.Bucket, DefaultBucket},
+	} {
+		if tt.got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.field, tt.got, tt.want)
+		}
+	}
+}
+
+func TestDefaultKeepsSetFields(t *testing.T) {
+	visual := &NapkinVisual{Spec: NapkinVisualSpec{
+		Content:    "Client calls the API",
+		Format:     "png",
+		Variations: 3,
+		TenantId:   "acme",
+		Style:      NapkinStyleSpec{ColorMode: "dark"},
+		Storage:    NapkinStorageSpec{Bucket: "acme-visuals"},
+	}}
+	want := visual.Spec.DeepCopy()
+	want.ApiKeySecretRef = SecretKeyRef{Name: DefaultApiKeySecretName, Key: DefaultApiKeySecretKey}
+
+	if err := (&NapkinVisualCustomDefaulter{}).Default(context.Background(), visual); err != nil {
+		t.Fatalf("Default: %v", err)
+	}
+	if visual.Spec.Format != want.Format || visual.Spec.Variations != want.Variations || visual.Spec.TenantId != want.TenantId ||
+		visual.Spec.Style.ColorMode != want.Style.ColorMode || visual.Spec.Storage.Bucket != want.Storage.Bucket ||
+		visual.Spec.ApiKeySecretRef != want.ApiKeySecretRef {
+		t.Errorf("expected set fields to be kept, got %+v", visual.Spec)
+	}
+}
+
+func TestValidateSpec(t *testing.T) {
+	longContent := strings.Repeat("Step. ", pptContentWarnLength/6+1)
+	tests := []struct {
+		name        string
+		mutate      func(spec *NapkinVisualSpec)
+		wantErr     string
+		wantWarning string
+	}{
+		{name: "valid", mutate: func(spec *NapkinVisualSpec) {}},
+		{name: "content and contentFrom", wantErr: "mutually exclusive", mutate: func(spec *NapkinVisualSpec) {
+			spec.ContentFrom = &ConfigMapKeyRef{Name: "content", Key: "text"}
+		}},
+		{name: "contentFrom only", mutate: func(spec *NapkinVisualSpec) {
+			spec.Content = ""
+			spec.ContentFrom = &ConfigMapKeyRef{Name: "content", Key: "text"}
+		}},
+		{name: "batch and contentFrom", wantErr: "cannot be combined with spec.batch", mutate: func(spec *NapkinVisualSpec) {
+			spec.Content = ""
+			spec.ContentFrom = &ConfigMapKeyRef{Name: "content", Key: "text"}
+			spec.Batch = []string{"First step", "Second step"}
+		}},
+		{name: "invalid content template", wantErr: "spec.content", mutate: func(spec *NapkinVisualSpec) {
+			spec.Content = "Deploy {{.service"
+			spec.Variables = map[string]string{"service": "payments"}
+		}},
+		{name: "undefined variable", wantErr: "spec.content", mutate: func(spec *NapkinVisualSpec) {
+			spec.Content = "Deploy {{.service}} to {{.region}}"
+			spec.Variables = map[string]string{"service": "payments"}
+		}},
+		{name: "empty after substitution", wantErr: "spec.content is empty after variable substitution", mutate: func(spec *NapkinVisualSpec) {
+			spec.Content = "{{.service}}"
+			spec.Variables = map[string]string{"service": "  "}
+		}},
+		{name: "empty batch item", wantErr: "spec.batch[1] is empty", mutate: func(spec *NapkinVisualSpec) {
+			spec.Content = ""
+			spec.Batch = []string{"First diagram", " "}
+		}},
+		{name: "long ppt content", wantWarning: "too many slides", mutate: func(spec *NapkinVisualSpec) {
+			spec.Format = "ppt"
+			spec.Content = longContent
+		}},
+		{name: "long svg content", mutate: func(spec *NapkinVisualSpec) {
+			spec.Content = longContent
+		}},
+		{name: "invalid context template", wantErr: "spec.context", mutate: func(spec *NapkinVisualSpec) {
+			spec.Context = "For {{.audience}}"
+			spec.Variables = map[string]string{"service": "payments"}
+		}},
+		{name: "width too large", wantErr: "spec.width", mutate: func(spec *NapkinVisualSpec) {
+			spec.Format = "png"
+			spec.Width = maxImageDimension + 1
+		}},
+		{name: "negative height", wantErr: "spec.height", mutate: func(spec *NapkinVisualSpec) {
+			spec.Format = "png"
+			spec.Height = -1
+		}},
+		{name: "dpi too low", wantErr: "spec.dpi", mutate: func(spec *NapkinVisualSpec) {
+			spec.Format = "png"
+			spec.DPI = minDPI - 1
+		}},
+		{name: "dpi too high", wantErr: "spec.dpi", mutate: func(spec *NapkinVisualSpec) {
+			spec.Format = "png"
+			spec.DPI = maxDPI + 1
+		}},
+		{name: "png size", mutate: func(spec *NapkinVisualSpec) {
+			spec.Format = "png"
+			spec.Width, spec.Height, spec.DPI = 1920, 1080, 300
+		}},
+		{name: "svg size", wantWarning: "no effect on svg output", mutate: func(spec *NapkinVisualSpec) {
+			spec.Width = 1920
+		}},
+		{name: "key template without index", wantErr: "spec.storage.keyTemplate", mutate: func(spec *NapkinVisualSpec) {
+			spec.Storage.KeyTemplate = "{{.tenant}}/{{.name}}.{{.format}}"
+		}},
+		{name: "valid key template", mutate: func(spec *NapkinVisualSpec) {
+			spec.Storage.KeyTemplate = "{{.date}}/{{.tenant}}/{{.name}}/{{.index}}.{{.format}}"
+		}},
+		{name: "invalid language", wantErr: "spec.language", mutate: func(spec *NapkinVisualSpec) {
+			spec.Language = "not a language"
+		}},
+		{name: "valid language", mutate: func(spec *NapkinVisualSpec) {
+			spec.Language = "pt-BR"
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			visual := &NapkinVisual{Spec: NapkinVisualSpec{Content: "Client calls the API"}}
+			visual.SetDefaults()
+			tt.mutate(&visual.Spec)
+
+			warnings, err := (&NapkinVisualCustomValidator{}).ValidateCreate(context.Background(), visual)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantWarning == "" {
+				if len(warnings) != 0 {
+					t.Errorf("unexpected warnings %v", warnings)
+				}
+				return
+			}
+			if len(warnings) != 1 || !strings.Contains(warnings[0], tt.wantWarning) {
+				t.Errorf("expected a warning containing %q, got %v", tt.wantWarning, warnings)
+			}
+		})
+	}
+}
+
+// storedVisual returns a visual whose files have already been generated
+func storedVisual() *NapkinVisual {
+	visual := &NapkinVisual{Spec: NapkinVisualSpec{Content: "Client calls the API", TenantId: "acme"}}
+	visual.Status.GeneratedFiles = []GeneratedFileStatus{{Index: 0, Format: "svg", MinioKey: "acme/diagram/0.svg"}}
+	return visual
+}
+
+func TestValidateUpdateRejectsStorageLocationChanges(t *testing.T) {
+	tests := []struct {
+		name    string
+		mutate  func(spec *NapkinVisualSpec)
+		wantErr string
+	}{
+		{name: "tenant", wantErr: "spec.tenantId", mutate: func(spec *NapkinVisualSpec) { spec.TenantId = "globex" }},
+		{name: "bucket", wantErr: "spec.storage.bucket", mutate: func(spec *NapkinVisualSpec) { spec.Storage.Bucket = "other" }},
+		{name: "bucket per tenant", wantErr: "spec.storage.bucketPerTenant", mutate: func(spec *NapkinVisualSpec) { spec.Storage.BucketPerTenant = true }},
+		{name: "prefix", wantErr: "spec.storage.prefix", mutate: func(spec *NapkinVisualSpec) { spec.Storage.Prefix = "archive/" }},
+		{name: "key template", wantErr: "spec.storage.keyTemplate", mutate: func(spec *NapkinVisualSpec) {
+			spec.Storage.KeyTemplate = "{{.date}}/{{.name}}/{{.index}}.{{.format}}"
+		}},
+		{name: "explicit default bucket", mutate: func(spec *NapkinVisualSpec) { spec.Storage.Bucket = DefaultBucket }},
+		{name: "content", mutate: func(spec *NapkinVisualSpec) { spec.Content = "Client calls the API twice" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old := storedVisual()
+			visual := old.DeepCopy()
+			tt.mutate(&visual.Spec)
+
+			_, err := (&NapkinVisualCustomValidator{}).ValidateUpdate(context.Background(), old, visual)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("ValidateUpdate: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+
+			// Before any files exist the location may still change
+			old.Status.GeneratedFiles = nil
+			if _, err := (&NapkinVisualCustomValidator{}).ValidateUpdate(context.Background(), old, visual); err != nil {
+				t.Errorf("ValidateUpdate without generated files: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateUpdateSkipsUnchangedSpec(t *testing.T) {
+	// Accepted before the language check existed; now invalid
+	old := storedVisual()
+	old.Spec.Language = "not a language tag!"
+	validator := &NapkinVisualCustomValidator{}
+	if _, err := validator.ValidateCreate(context.Background(), old); err == nil {
+		t.Fatal("expected the spec to fail validation on create")
+	}
+
+	// Removing the finalizer or applying defaults leaves the spec as it was
+	visual := old.DeepCopy()
+	visual.Finalizers = nil
+	visual.SetDefaults()
+	if _, err := validator.ValidateUpdate(context.Background(), old, visual); err != nil {
+		t.Errorf("expected an unchanged spec to be accepted, got %v", err)
+	}
+
+	visual.Spec.Variations = 2
+	if _, err := validator.ValidateUpdate(context.Background(), old, visual); err == nil {
+		t.Error("expected a changed spec to be validated")
+	}
+}
@@ -8,6 +8,36 @@ import (
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BatchItemStatus) DeepCopyInto(out *BatchItemStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BatchItemStatus.
+func (in *BatchItemStatus) DeepCopy() *BatchItemStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(BatchItemStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ConfigMapKeyRef) DeepCopyInto(out *ConfigMapKeyRef) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ConfigMapKeyRef.
+func (in *ConfigMapKeyRef) DeepCopy() *ConfigMapKeyRef {
+	if in == nil {
+		return nil
+	}
+	out := new(ConfigMapKeyRef)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *GeneratedFileStatus) DeepCopyInto(out *GeneratedFileStatus) {
 	*out = *in
@@ -58,7 +88,7 @@ func (in *NapkinVisual) DeepCopyInto(out *NapkinVisual) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
+	in.Spec.DeepCopyInto(&out.Spec)
 	in.Status.DeepCopyInto(&out.Status)
 }
 
@@ -131,9 +161,31 @@ func (in *NapkinVisualList) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *NapkinVisualSpec) DeepCopyInto(out *NapkinVisualSpec) {
 	*out = *in
+	if in.ContentFrom != nil {
+		in, out := &in.ContentFrom, &out.ContentFrom
+		*out = new(ConfigMapKeyRef)
+		**out = **in
+	}
+	if in.Batch != nil {
+		in, out := &in.Batch, &out.Batch
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 	out.Style = in.Style
+	if in.Variables != nil {
+		in, out := &in.Variables, &out.Variables
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
 	out.ApiKeySecretRef = in.ApiKeySecretRef
 	out.Storage = in.Storage
+	if in.MaxRetries != nil {
+		in, out := &in.MaxRetries, &out.MaxRetries
+		*out = new(int)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NapkinVisualSpec.
@@ -156,6 +208,16 @@ func (in *NapkinVisualStatus) DeepCopyInto(out *NapkinVisualStatus) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.SubmittedRequest != nil {
+		in, out := &in.SubmittedRequest, &out.SubmittedRequest
+		*out = new(SubmittedRequestStatus)
+		**out = **in
+	}
+	if in.BatchItems != nil {
+		in, out := &in.BatchItems, &out.BatchItems
+		*out = make([]BatchItemStatus, len(*in))
+		copy(*out, *in)
+	}
 	if in.GeneratedFiles != nil {
 		in, out := &in.GeneratedFiles, &out.GeneratedFiles
 		*out = make([]GeneratedFileStatus, len(*in))
@@ -195,3 +257,18 @@ func (in *SecretKeyRef) DeepCopy() *SecretKeyRef {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SubmittedRequestStatus) DeepCopyInto(out *SubmittedRequestStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SubmittedRequestStatus.
+func (in *SubmittedRequestStatus) DeepCopy() *SubmittedRequestStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(SubmittedRequestStatus)
+	in.DeepCopyInto(out)
+	return out
+}
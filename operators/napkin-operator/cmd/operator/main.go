@@ -1,8 +1,13 @@
 package main
 
 import (
+	"context"
+	"crypto/x509"
 	"flag"
+	"fmt"
+	"net/http"
 	"os"
+	"time"
 
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
@@ -17,6 +22,8 @@ import (
 	napkinv1 "github.com/Tributary-ai-services/napkin-operator/api/v1"
 	"github.com/Tributary-ai-services/napkin-operator/pkg/controllers"
 	minioclient "github.com/Tributary-ai-services/napkin-operator/pkg/minio"
+	napkinclient "github.com/Tributary-ai-services/napkin-operator/pkg/napkin"
+	"github.com/Tributary-ai-services/napkin-operator/pkg/storage"
 )
 
 var (
@@ -32,19 +39,59 @@ func init() {
 func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
+	var enableWebhooks bool
 	var probeAddr string
 	var napkinURL string
+	var napkinAPIKey string
+	var apiKeyDir string
+	var napkinRateLimit float64
+	var napkinBurst int
+	var storageBackend string
+	var storagePath string
 	var minioEndpoint string
 	var minioAccessKey string
 	var minioSecretKey string
+	var minioUseSSL bool
+	var minioRegion string
+	var minioPathStyle bool
+	var minioInsecureTLS bool
+	var minioCACert string
+	var minioPartSizeMB int
+	var minioUploadThreads int
+	var downloadConcurrency int
+	var maxConcurrentReconciles int
+	var maxInflightPerTenant int
+	var maxStorageAttempts int
+	var maxPollInterval time.Duration
+	var allowPrivateCallbacks bool
 
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8088", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8089", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false, "Enable leader election for controller manager.")
+	flag.BoolVar(&enableWebhooks, "enable-webhooks", getEnv("ENABLE_WEBHOOKS", "") == "true", "Enable the NapkinVisual admission webhooks (requires serving certificates).")
 	flag.StringVar(&napkinURL, "napkin-url", getEnv("NAPKIN_API_BASE_URL", "https://api.napkin.ai"), "Napkin AI API base URL")
+	flag.StringVar(&napkinAPIKey, "napkin-api-key", getEnv("NAPKIN_API_KEY", ""), "Default Napkin AI API key used when a NapkinVisual has no readable Secret or key file")
+	flag.StringVar(&apiKeyDir, "api-key-dir", getEnv("NAPKIN_API_KEY_DIR", ""), "Directory that NapkinVisual spec.apiKeyFile paths must resolve into (empty disables key files)")
+	flag.Float64Var(&napkinRateLimit, "napkin-rate-limit", 0, "Maximum Napkin API requests per second across all NapkinVisuals (0 disables limiting)")
+	flag.IntVar(&napkinBurst, "napkin-burst", 5, "Burst size for the Napkin API rate limit")
+	flag.StringVar(&storageBackend, "storage-backend", getEnv("STORAGE_BACKEND", "minio"), "Storage backend for generated visuals: minio or filesystem")
+	flag.StringVar(&storagePath, "storage-path", getEnv("STORAGE_PATH", "/data/napkin-visuals"), "Root directory for the filesystem storage backend")
 	flag.StringVar(&minioEndpoint, "minio-endpoint", getEnv("MINIO_ENDPOINT", "minio-shared.tas-shared.svc.cluster.local:9000"), "MinIO endpoint")
 	flag.StringVar(&minioAccessKey, "minio-access-key", getEnv("MINIO_ACCESS_KEY", "minioadmin"), "MinIO access key")
 	flag.StringVar(&minioSecretKey, "minio-secret-key", getEnv("MINIO_SECRET_KEY", "minioadmin123"), "MinIO secret key")
+	flag.BoolVar(&minioUseSSL, "minio-use-ssl", getEnv("MINIO_USE_SSL", "") == "true", "Use TLS when connecting to MinIO")
+	flag.StringVar(&minioRegion, "minio-region", getEnv("MINIO_REGION", ""), "MinIO bucket region (empty for auto-detection)")
+	flag.BoolVar(&minioPathStyle, "minio-path-style", getEnv("MINIO_PATH_STYLE", "") == "true", "Use path-style bucket addressing for MinIO")
+	flag.BoolVar(&minioInsecureTLS, "minio-insecure-tls", getEnv("MINIO_INSECURE_TLS", "") == "true", "Skip TLS certificate verification when connecting to MinIO")
+	flag.StringVar(&minioCACert, "minio-ca-cert", getEnv("MINIO_CA_CERT", ""), "Path to a PEM CA bundle used to verify the MinIO TLS certificate")
+	flag.IntVar(&minioPartSizeMB, "minio-part-size-mb", 5, "Multipart part size in MiB; larger objects are uploaded in parts (minimum 5)")
+	flag.IntVar(&minioUploadThreads, "minio-upload-concurrency", 4, "Number of multipart parts uploaded to MinIO in parallel")
+	flag.IntVar(&downloadConcurrency, "download-concurrency", 4, "Maximum number of generated files downloaded and uploaded in parallel per visual")
+	flag.IntVar(&maxConcurrentReconciles, "max-concurrent-reconciles", 1, "Maximum number of NapkinVisuals reconciled concurrently")
+	flag.IntVar(&maxInflightPerTenant, "max-inflight-per-tenant", 0, "Maximum in-flight generations per tenant; further NapkinVisuals wait in Pending (0 disables the limit)")
+	flag.IntVar(&maxStorageAttempts, "max-storage-attempts", 10, "Failed attempts to store generated files before a NapkinVisual fails")
+	flag.DurationVar(&maxPollInterval, "max-poll-interval", time.Minute, "Maximum delay between status polls when Napkin reports a completion estimate")
+	flag.BoolVar(&allowPrivateCallbacks, "callback-allow-private-networks", false, "Allow spec.callbackUrl to reach loopback, link-local and private network addresses")
 
 	opts := zap.Options{Development: true}
 	opts.BindFlags(flag.CommandLine)
@@ -57,23 +104,64 @@ func main() {
 		"metrics-addr", metricsAddr,
 		"probe-addr", probeAddr,
 		"leader-election", enableLeaderElection,
+		"webhooks", enableWebhooks,
+		"max-concurrent-reconciles", maxConcurrentReconciles,
 		"napkin-url", napkinURL,
 		"minio-endpoint", minioEndpoint,
 	)
 
-	// Initialize MinIO client
-	mc, err := minioclient.NewClient(minioEndpoint, minioAccessKey, minioSecretKey, false)
-	if err != nil {
-		setupLog.Error(err, "Failed to create MinIO client")
+	napkinclient.SetRateLimit(napkinRateLimit, napkinBurst)
+
+	var store storage.Storage
+	switch storageBackend {
+	case "minio":
+		var minioCAPEM []byte
+		if minioCACert != "" {
+			pem, err := os.ReadFile(minioCACert)
+			if err != nil {
+				setupLog.Error(err, "Failed to read MinIO CA certificate", "path", minioCACert)
+				os.Exit(1)
+			}
+			if !x509.NewCertPool().AppendCertsFromPEM(pem) {
+				setupLog.Error(fmt.Errorf("no certificates found"), "Invalid MinIO CA certificate", "path", minioCACert)
+				os.Exit(1)
+			}
+			minioCAPEM = pem
+		}
+
+		// Initialize MinIO client
+		mc, err := minioclient.NewClient(minioEndpoint, minioAccessKey, minioSecretKey, minioUseSSL,
+			minioclient.WithRegion(minioRegion),
+			minioclient.WithPathStyle(minioPathStyle),
+			minioclient.WithInsecureSkipVerify(minioInsecureTLS),
+			minioclient.WithCACert(minioCAPEM),
+			minioclient.WithPartSize(uint64(max(minioPartSizeMB, 0))<<20),
+			minioclient.WithUploadConcurrency(minioUploadThreads),
+		)
+		if err != nil {
+			setupLog.Error(err, "Failed to create MinIO client")
+			os.Exit(1)
+		}
+
+		// Set public URL for external-facing download links
+		if publicURL := getEnv("MINIO_PUBLIC_URL", ""); publicURL != "" {
+			mc.SetPublicURL(publicURL)
+			setupLog.Info("MinIO public URL configured", "url", publicURL)
+		}
+		store = mc
+	case "filesystem":
+		fsStore, err := storage.NewFilesystem(storagePath, getEnv("STORAGE_PUBLIC_URL", ""))
+		if err != nil {
+			setupLog.Error(err, "Failed to create filesystem storage", "path", storagePath)
+			os.Exit(1)
+		}
+		setupLog.Info("Using filesystem storage", "path", storagePath)
+		store = fsStore
+	default:
+		setupLog.Error(fmt.Errorf("unknown storage backend %q", storageBackend), "Invalid --storage-backend; expected minio or filesystem")
 		os.Exit(1)
 	}
 
-	// Set public URL for external-facing download links
-	if publicURL := getEnv("MINIO_PUBLIC_URL", ""); publicURL != "" {
-		mc.SetPublicURL(publicURL)
-		setupLog.Info("MinIO public URL configured", "url", publicURL)
-	}
-
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme: scheme,
 		Metrics: server.Options{
@@ -90,21 +178,46 @@ func main() {
 	}
 
 	if err = (&controllers.NapkinVisualReconciler{
-		Client:      mgr.GetClient(),
-		Scheme:      mgr.GetScheme(),
-		NapkinURL:   napkinURL,
-		MinioClient: mc,
+		Client:                  mgr.GetClient(),
+		Scheme:                  mgr.GetScheme(),
+		NapkinURL:               napkinURL,
+		DefaultAPIKey:           napkinAPIKey,
+		APIKeyDir:               apiKeyDir,
+		NapkinClients:           napkinclient.NewClientCache(napkinURL),
+		Recorder:                mgr.GetEventRecorderFor("napkin-operator"),
+		APIReader:               mgr.GetAPIReader(),
+		StyleCache:              napkinclient.NewStyleCache(10 * time.Minute),
+		Storage:                 store,
+		DownloadConcurrency:     downloadConcurrency,
+		MaxConcurrentReconciles: maxConcurrentReconciles,
+		MaxInflightPerTenant:    maxInflightPerTenant,
+		MaxStorageAttempts:      maxStorageAttempts,
+		MaxPollInterval:         maxPollInterval,
+		AllowPrivateCallbacks:   allowPrivateCallbacks,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "Unable to create controller", "controller", "NapkinVisual")
 		os.Exit(1)
 	}
 
+	if enableWebhooks {
+		if err = napkinv1.SetupNapkinVisualWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "Unable to create webhook", "webhook", "NapkinVisual")
+			os.Exit(1)
+		}
+	}
+
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		setupLog.Error(err, "Unable to set up health check")
 		os.Exit(1)
 	}
 
-	if err := mgr.AddReadyzCheck("readyz", healthz.Ping); err != nil {
+	// The webhooks are served only while the pod is ready, so with webhooks
+	// enabled a storage outage must not make it unready and block admission
+	readyStore := store
+	if enableWebhooks {
+		readyStore = nil
+	}
+	if err := mgr.AddReadyzCheck("readyz", readyzCheck(mgr.GetCache().WaitForCacheSync, readyStore)); err != nil {
 		setupLog.Error(err, "Unable to set up ready check")
 		os.Exit(1)
 	}
@@ -116,6 +229,23 @@ func main() {
 	}
 }
 
+// readyzCheck reports ready once the informer cache has synced and, unless
+// store is nil, storage is reachable
+func readyzCheck(cacheSynced func(context.Context) bool, store storage.Storage) healthz.Checker {
+	return func(req *http.Request) error {
+		ctx, cancel := context.WithTimeout(req.Context(), 2*time.Second)
+		defer cancel()
+
+		if !cacheSynced(ctx) {
+			return fmt.Errorf("informer cache not synced")
+		}
+		if store == nil {
+			return nil
+		}
+		return store.Ping(ctx, napkinv1.DefaultBucket)
+	}
+}
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	napkinv1 "github.com/Tributary-ai-services/napkin-operator/api/v1"
+	"github.com/Tributary-ai-services/napkin-operator/pkg/storage"
+)
+
+// stubStorage answers Ping with err; other methods are not used by readiness
+type stubStorage struct {
+	storage.Storage
+	err    error
+	bucket string
+}
+
+func (s *stubStorage) Ping(ctx context.Context, bucket string) error {
+	s.bucket = bucket
+	return s.err
+}
+
+func TestReadyzCheck(t *testing.T) {
+	synced := func(context.Context) bool { return true }
+	unsynced := func(context.Context) bool { return false }
+
+	tests := []struct {
+		name        string
+		cacheSynced func(context.Context) bool
+		store       *stubStorage
+		wantErr     bool
+	}{
+		{name: "ready", cacheSynced: synced, store: &stubStorage{}},
+		{name: "cache not synced", cacheSynced: unsynced, store: &stubStorage{}, wantErr: true},
+		{name: "storage unreachable", cacheSynced: synced, store: &stubStorage{err: fmt.Errorf("connection refused")}, wantErr: true},
+		{name: "storage not probed", cacheSynced: synced},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var store storage.Storage
+			if tt.store != nil {
+				store = tt.store
+			}
+			err := readyzCheck(tt.cacheSynced, store)(httptest.NewRequest("GET", "/readyz", nil))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if tt.store != nil && tt.cacheSynced(context.Background()) && tt.store.bucket != napkinv1.DefaultBucket {
+				t.Errorf("expected the default bucket to be probed, got %q", tt.store.bucket)
+			}
+		})
+	}
+}
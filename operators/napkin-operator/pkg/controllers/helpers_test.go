@@ -0,0 +1,379 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	napkinv1 "github.com/Tributary-ai-services/napkin-operator/api/v1"
+	napkinclient "github.com/Tributary-ai-services/napkin-operator/pkg/napkin"
+	"github.com/Tributary-ai-services/napkin-operator/pkg/storage"
+)
+
+const testNamespace = "default"
+
+// svgData is a minimal payload that passes the svg format check
+var svgData = []byte(`<svg xmlns="http://www.w3.org/2000/svg"></svg>`)
+
+// fakeNapkin is an httptest Napkin API. Submissions get sequential request
+// IDs and report completed with one svg file per variation unless a status
+// is set for the ID.
+type fakeNapkin struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	submits []napkinclient.SubmitRequest
+	cancels []string
+	status  map[string]napkinclient.StatusResponse
+	styles  []napkinclient.Style
+	// stylesStatus, if set, fails style listing with this HTTP status
+	stylesStatus int
+	files        map[string][]byte // download path -> data; missing paths return 404
+
+	// submitStatus returns the HTTP status for the nth (0-based) submission; 0 accepts it
+	submitStatus func(n int) int
+	// submitDelay is added to every submission
+	submitDelay time.Duration
+
+	downloadDelay time.Duration
+	inflight      int
+	maxInflight   int
+}
+
+func newFakeNapkin(t *testing.T) *fakeNapkin {
+	t.Helper()
+	f := &fakeNapkin{
+		status: make(map[string]napkinclient.StatusResponse),
+		files:  make(map[string][]byte),
+	}
+	f.Server = httptest.NewServer(f)
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeNapkin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/v1/visual":
+		f.serveSubmit(w, r)
+	case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/status"):
+		id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/v1/visual/"), "/status")
+		f.mu.Lock()
+		status, ok := f.status[id]
+		f.mu.Unlock()
+		if !ok {
+			status = napkinclient.StatusResponse{ID: id, Status: "processing"}
+		}
+		json.NewEncoder(w).Encode(status)
+	case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/v1/visual/"):
+		f.mu.Lock()
+		f.cancels = append(f.cancels, strings.TrimPrefix(r.URL.Path, "/v1/visual/"))
+		f.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodGet && r.URL.Path == "/v1/styles":
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if f.stylesStatus != 0 {
+			http.Error(w, "styles unavailable", f.stylesStatus)
+			return
+		}
+		json.NewEncoder(w).Encode(napkinclient.ListStylesResponse{Styles: f.styles})
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/files/"):
+		f.serveFile(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeNapkin) serveSubmit(w http.ResponseWriter, r *http.Request) {
+	var req napkinclient.SubmitRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	time.Sleep(f.submitDelay)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := len(f.submits)
+	f.submits = append(f.submits, req)
+	if f.submitStatus != nil {
+		if code := f.submitStatus(n); code != 0 {
+			http.Error(w, "submission rejected", code)
+			return
+		}
+	}
+	json.NewEncoder(w).Encode(napkinclient.SubmitResponse{ID: fmt.Sprintf("req-%d", n), Status: "pending"})
+}
+
+func (f *fakeNapkin) serveFile(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	data, ok := f.files[r.URL.Path]
+	f.inflight++
+	f.maxInflight = max(f.maxInflight, f.inflight)
+	f.mu.Unlock()
+
+	time.Sleep(f.downloadDelay)
+
+	f.mu.Lock()
+	f.inflight--
+	f.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Write(data)
+}
+
+// complete makes the request report completed with the given files
+func (f *fakeNapkin) complete(id string, files ...napkinclient.FileInfo) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.status[id] = napkinclient.StatusResponse{ID: id, Status: "completed", Files: files}
+}
+
+// addFile serves data for download and returns its file info
+func (f *fakeNapkin) addFile(index int, format, colorMode string, data []byte) napkinclient.FileInfo {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p := fmt.Sprintf("/files/%d-%s.%s", index, colorMode, format)
+	f.files[p] = data
+	return napkinclient.FileInfo{Index: index, Format: format, ColorMode: colorMode, URL: f.URL + p}
+}
+
+func (f *fakeNapkin) submitted() []napkinclient.SubmitRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]napkinclient.SubmitRequest(nil), f.submits...)
+}
+
+func (f *fakeNapkin) cancelled() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.cancels...)
+}
+
+// memStorage is an in-memory storage.Storage with failure injection
+type memStorage struct {
+	mu      sync.Mutex
+	objects map[string][]byte            // bucket/key -> data
+	tags    map[string]map[string]string // bucket/key -> tags
+	uploads int
+
+	// uploadErr, if set, is returned for uploads when it yields an error
+	uploadErr func(key string) error
+	ensureErr error
+}
+
+var _ storage.Storage = &memStorage{}
+
+func newMemStorage() *memStorage {
+	return &memStorage{
+		objects: make(map[string][]byte),
+		tags:    make(map[string]map[string]string),
+	}
+}
+
+func (m *memStorage) EnsureBucket(ctx context.Context, bucket string, expireAfterDays int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.ensureErr
+}
+
+func (m *memStorage) UploadWithTags(ctx context.Context, bucket, key string, data []byte, contentType string, objectTags map[string]string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.uploadErr != nil {
+		if err := m.uploadErr(key); err != nil {
+			return "", err
+		}
+	}
+	m.uploads++
+	m.objects[bucket+"/"+key] = data
+	m.tags[bucket+"/"+key] = objectTags
+	return m.ObjectURL(bucket, key), nil
+}
+
+func (m *memStorage) Download(ctx context.Context, bucket, key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.objects[bucket+"/"+key]
+	if !ok {
+		return nil, fmt.Errorf("object %s/%s not found", bucket, key)
+	}
+	return data, nil
+}
+
+func (m *memStorage) Delete(ctx context.Context, bucket, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.objects, bucket+"/"+key)
+	return nil
+}
+
+func (m *memStorage) List(ctx context.Context, bucket, prefix string) ([]storage.ObjectInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var objects []storage.ObjectInfo
+	for path, data := range m.objects {
+		b, key, _ := strings.Cut(path, "/")
+		if b == bucket && strings.HasPrefix(key, prefix) {
+			objects = append(objects, storage.ObjectInfo{Key: key, Size: int64(len(data))})
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+func (m *memStorage) ObjectURL(bucket, key string) string {
+	return "mem://" + bucket + "/" + key
+}
+
+func (m *memStorage) Ping(ctx context.Context, bucket string) error {
+	return nil
+}
+
+// keys returns the stored bucket/key paths in order
+func (m *memStorage) keys() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var keys []string
+	for key := range m.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func newTestScheme(t *testing.T) *runtime.Scheme {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := napkinv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	return scheme
+}
+
+// newTestReconciler returns a reconciler backed by a fake client holding objs,
+// a fake Napkin API and in-memory storage
+func newTestReconciler(t *testing.T, objs ...client.Object) (*NapkinVisualReconciler, *fakeNapkin, *memStorage) {
+	t.Helper()
+	scheme := newTestScheme(t)
+	c := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(objs...).
+		WithStatusSubresource(&napkinv1.NapkinVisual{}).
+		WithIndex(&napkinv1.NapkinVisual{}, contentFromIndex, indexContentFrom).
+		Build()
+
+	napkin := newFakeNapkin(t)
+	store := newMemStorage()
+	r := &NapkinVisualReconciler{
+		Client:        c,
+		Scheme:        scheme,
+		tracer:        otel.Tracer("test"),
+		NapkinURL:     napkin.URL,
+		DefaultAPIKey: "test-key",
+		Storage:       store,
+		Recorder:      record.NewFakeRecorder(100),
+	}
+	return r, napkin, store
+}
+
+// newTestVisual returns a defaulted visual that already carries the finalizer
+func newTestVisual(name string) *napkinv1.NapkinVisual {
+	visual := &napkinv1.NapkinVisual{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       name,
+			Namespace:  testNamespace,
+			UID:        types.UID(name + "-uid"),
+			Finalizers: []string{finalizerName},
+		},
+		Spec: napkinv1.NapkinVisualSpec{Content: "Client calls the API"},
+	}
+	visual.SetDefaults()
+	return visual
+}
+
+// withPhase sets the visual's phase as if it had been reconciled that far
+func withPhase(visual *napkinv1.NapkinVisual, phase string) *napkinv1.NapkinVisual {
+	now := metav1.Now()
+	visual.Status.Phase = phase
+	visual.Status.StartTime = &now
+	return visual
+}
+
+func reconcileVisual(t *testing.T, r *NapkinVisualReconciler, name string) ctrl.Result {
+	t.Helper()
+	result, err := r.Reconcile(context.Background(), ctrl.Request{
+		NamespacedName: types.NamespacedName{Namespace: testNamespace, Name: name},
+	})
+	if err != nil {
+		t.Fatalf("Reconcile: %v", err)
+	}
+	return result
+}
+
+func getVisual(t *testing.T, c client.Reader, name string) *napkinv1.NapkinVisual {
+	t.Helper()
+	var visual napkinv1.NapkinVisual
+	if err := c.Get(context.Background(), types.NamespacedName{Namespace: testNamespace, Name: name}, &visual); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	return &visual
+}
+
+func readyReason(visual *napkinv1.NapkinVisual) string {
+	if cond := findCondition(visual, "Ready"); cond != nil {
+		return cond.Reason
+	}
+	return ""
+}
+
+// drainEvents returns the events recorded so far
+func drainEvents(r *NapkinVisualReconciler) []string {
+	recorder := r.Recorder.(*record.FakeRecorder)
+	var events []string
+	for {
+		select {
+		case event := <-recorder.Events:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+// reconcileUntil reconciles the visual until it reaches phase, failing the
+// test if it doesn't within a few reconciles
+func reconcileUntil(t *testing.T, r *NapkinVisualReconciler, name, phase string) *napkinv1.NapkinVisual {
+	t.Helper()
+	for i := 0; i < 10; i++ {
+		reconcileVisual(t, r, name)
+		if visual := getVisual(t, r, name); visual.Status.Phase == phase {
+			return visual
+		}
+	}
+	visual := getVisual(t, r, name)
+	t.Fatalf("expected %s, got %s (%s)", phase, visual.Status.Phase, visual.Status.LastError)
+	return nil
+}
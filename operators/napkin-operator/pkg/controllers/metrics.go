@@ -0,0 +1,63 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	napkinv1 "github.com/Tributary-ai-services/napkin-operator/api/v1"
+)
+
+// phaseCollectorTimeout bounds the cache list performed on each scrape
+const phaseCollectorTimeout = 5 * time.Second
+
+// phaseCollector exports napkin_visuals_by_phase by counting NapkinVisuals in
+// the informer cache at scrape time, so series for deleted objects or vacated
+// phases disappear without bookkeeping.
+type phaseCollector struct {
+	reader client.Reader
+	desc   *prometheus.Desc
+}
+
+func newPhaseCollector(reader client.Reader) *phaseCollector {
+	return &phaseCollector{
+		reader: reader,
+		desc: prometheus.NewDesc(
+			"napkin_visuals_by_phase",
+			"Number of NapkinVisuals in each phase",
+			[]string{"namespace", "phase"}, nil,
+		),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *phaseCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+// Collect implements prometheus.Collector
+func (c *phaseCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), phaseCollectorTimeout)
+	defer cancel()
+
+	var visuals napkinv1.NapkinVisualList
+	if err := c.reader.List(ctx, &visuals); err != nil {
+		ctrl.Log.WithName("metrics").Error(err, "Failed to list NapkinVisuals for phase metrics")
+		return
+	}
+
+	counts := make(map[[2]string]int)
+	for _, visual := range visuals.Items {
+		phase := visual.Status.Phase
+		if phase == "" {
+			phase = phasePending
+		}
+		counts[[2]string{visual.Namespace, phase}]++
+	}
+	for key, count := range counts {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(count), key[0], key[1])
+	}
+}
@@ -0,0 +1,71 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestPhaseCollectorCountsVisualsByPhase(t *testing.T) {
+	elsewhere := withPhase(newTestVisual("elsewhere"), phaseCompleted)
+	elsewhere.Namespace = "other-team"
+	unset := newTestVisual("new")
+	r, _, _ := newTestReconciler(t,
+		withPhase(newTestVisual("queued"), phasePending),
+		unset,
+		withPhase(newTestVisual("sent"), phaseSubmitted),
+		withPhase(newTestVisual("running-1"), phaseProcessing),
+		withPhase(newTestVisual("running-2"), phaseProcessing),
+		withPhase(newTestVisual("fetching"), phaseDownloading),
+		withPhase(newTestVisual("done"), phaseCompleted),
+		withPhase(newTestVisual("broken"), phaseFailed),
+		elsewhere,
+	)
+	collector := newPhaseCollector(r.Client)
+
+	const header = `
+# HELP napkin_visuals_by_phase Number of NapkinVisuals in each phase
+# TYPE napkin_visuals_by_phase gauge
+`
+	want := header + `
+napkin_visuals_by_phase{namespace="default",phase="Completed"} 1
+napkin_visuals_by_phase{namespace="default",phase="Downloading"} 1
+napkin_visuals_by_phase{namespace="default",phase="Failed"} 1
+napkin_visuals_by_phase{namespace="default",phase="Pending"} 2
+napkin_visuals_by_phase{namespace="default",phase="Processing"} 2
+napkin_visuals_by_phase{namespace="default",phase="Submitted"} 1
+napkin_visuals_by_phase{namespace="other-team",phase="Completed"} 1
+`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(want)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Deleted visuals and vacated phases drop out of the next scrape
+	for _, name := range []string{"broken", "fetching"} {
+		visual := getVisual(t, r, name)
+		visual.Finalizers = nil
+		if err := r.Update(context.Background(), visual); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Delete(context.Background(), visual); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sent := getVisual(t, r, "sent")
+	sent.Status.Phase = phaseProcessing
+	if err := r.Status().Update(context.Background(), sent); err != nil {
+		t.Fatal(err)
+	}
+
+	want = header + `
+napkin_visuals_by_phase{namespace="default",phase="Completed"} 1
+napkin_visuals_by_phase{namespace="default",phase="Pending"} 2
+napkin_visuals_by_phase{namespace="default",phase="Processing"} 3
+napkin_visuals_by_phase{namespace="other-team",phase="Completed"} 1
+`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(want)); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,286 @@
+package controllers
+
+import (
+	"context"
+	goerrors "errors"
+	"fmt"
+	"time"
+
+	"k8s.io/client-go/util/retry"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	napkinv1 "github.com/Tributary-ai-services/napkin-operator/api/v1"
+)
+
+// reconcileBatchPending submits one Napkin request per batch item
+func (r *NapkinVisualReconciler) reconcileBatchPending(ctx context.Context, visual *napkinv1.NapkinVisual) (ctrl.Result, error) {
+	ctx, span := r.tracer.Start(ctx, "reconcile_batch_pending")
+	defer span.End()
+	logger := log.FromContext(ctx)
+
+	// A requeue can race the status write of an earlier pass, so start from
+	// the live status rather than the cached one before submitting anything
+	latest := &napkinv1.NapkinVisual{}
+	if err := r.reader().Get(ctx, client.ObjectKeyFromObject(visual), latest); err != nil {
+		return ctrl.Result{}, err
+	}
+	if latest.Status.Phase != phasePending {
+		logger.Info("Skipping batch submission; visual is no longer pending", "phase", latest.Status.Phase)
+		return ctrl.Result{Requeue: true}, nil
+	}
+	visual.Status = latest.Status
+	visual.ResourceVersion = latest.ResourceVersion
+
+	apiKey, err := r.getAPIKey(ctx, visual)
+	if err != nil {
+		r.setFailedStatus(ctx, visual, fmt.Sprintf("Failed to read API key: %v", err))
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+	}
+
+	genContext, err := napkinv1.RenderTemplate("context", visual.Spec.Context, visual.Spec.Variables)
+	if err != nil {
+		r.setFailedStatus(ctx, visual, fmt.Sprintf("Failed to render context: %v", err))
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+	}
+
+	napkin := r.napkinClient(apiKey)
+	r.validateStyle(ctx, visual, napkin)
+	initBatchItems(visual)
+	for i := range visual.Status.BatchItems {
+		if visual.Status.BatchItems[i].Phase != phasePending {
+			continue
+		}
+
+		content, renderErr := napkinv1.RenderTemplate(fmt.Sprintf("batch[%d]", i), visual.Spec.Batch[i], visual.Spec.Variables)
+		if renderErr != nil {
+			err := r.updateBatchItem(ctx, visual, i, func(item *napkinv1.BatchItemStatus) {
+				item.Phase = phaseFailed
+				item.Error = fmt.Sprintf("Failed to render content: %v", renderErr)
+			})
+			if err != nil {
+				return r.batchUpdateFailed(ctx, err)
+			}
+			continue
+		}
+
+		resp, submitErr := napkin.Submit(ctx, buildSubmitRequest(visual, content, genContext))
+		if submitErr != nil {
+			// Submission errors are usually transient, so the item stays
+			// Pending until it has used up the visual's retries
+			logger.Error(submitErr, "Failed to submit batch item", "batchIndex", i)
+			err := r.updateBatchItem(ctx, visual, i, func(item *napkinv1.BatchItemStatus) {
+				item.SubmitAttempts++
+				item.Error = fmt.Sprintf("Failed to submit: %v", submitErr)
+				if item.SubmitAttempts >= max(maxRetries(visual), 1) {
+					item.Phase = phaseFailed
+				}
+			})
+			if err != nil {
+				return r.batchUpdateFailed(ctx, err)
+			}
+			continue
+		}
+
+		err := r.updateBatchItem(ctx, visual, i, func(item *napkinv1.BatchItemStatus) {
+			item.Phase = phaseSubmitted
+			item.NapkinRequestId = resp.ID
+			item.Error = ""
+		})
+		if err != nil {
+			// The job can't be tracked, so cancel it rather than leave it orphaned
+			if cerr := napkin.Cancel(ctx, resp.ID); cerr != nil {
+				logger.Error(cerr, "Failed to cancel untracked Napkin request", "requestId", resp.ID, "batchIndex", i)
+			}
+			return r.batchUpdateFailed(ctx, err)
+		}
+	}
+
+	pending, submitted := 0, 0
+	for _, item := range visual.Status.BatchItems {
+		switch {
+		case item.Phase == phasePending:
+			pending++
+		case item.NapkinRequestId != "":
+			submitted++
+		}
+	}
+	if pending > 0 {
+		logger.Info("Batch items failed to submit, retrying", "pending", pending, "submitted", submitted)
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+	}
+	if submitted == 0 {
+		r.setFailedStatus(ctx, visual, "All batch items failed to submit")
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+	}
+
+	hash := specHash(visual, visual.Spec.Content)
+	err = r.updateLiveStatus(ctx, visual, func(latest *napkinv1.NapkinVisual) error {
+		if latest.Status.Phase != phasePending {
+			return errAlreadySubmitted
+		}
+		latest.Status.Phase = phaseSubmitted
+		latest.Status.SpecHash = hash
+		removeCondition(latest, "QuotaExceeded")
+		return nil
+	})
+	if err != nil {
+		return r.batchUpdateFailed(ctx, err)
+	}
+
+	return ctrl.Result{RequeueAfter: 5 * time.Second}, nil
+}
+
+// initBatchItems creates a Pending status entry per batch item unless the
+// entries already match the spec
+func initBatchItems(visual *napkinv1.NapkinVisual) {
+	if len(visual.Status.BatchItems) == len(visual.Spec.Batch) {
+		return
+	}
+	items := make([]napkinv1.BatchItemStatus, len(visual.Spec.Batch))
+	for i := range items {
+		items[i] = napkinv1.BatchItemStatus{Index: i, Phase: phasePending}
+	}
+	visual.Status.BatchItems = items
+}
+
+// updateBatchItem applies mutate to batch item i of the live visual and
+// persists it. errAlreadySubmitted is returned if another reconcile moved the
+// visual or the item past Pending first.
+func (r *NapkinVisualReconciler) updateBatchItem(ctx context.Context, visual *napkinv1.NapkinVisual, i int, mutate func(*napkinv1.BatchItemStatus)) error {
+	return r.updateLiveStatus(ctx, visual, func(latest *napkinv1.NapkinVisual) error {
+		if latest.Status.Phase != phasePending {
+			return errAlreadySubmitted
+		}
+		initBatchItems(latest)
+		if i >= len(latest.Status.BatchItems) {
+			return errAlreadySubmitted
+		}
+		item := &latest.Status.BatchItems[i]
+		if item.Phase != phasePending || item.NapkinRequestId != "" {
+			return errAlreadySubmitted
+		}
+		mutate(item)
+		return nil
+	})
+}
+
+// updateLiveStatus applies mutate to the status of the live visual, persists
+// it and copies the result back. The live object is read into a copy so the
+// in-memory spec defaults of visual are kept.
+func (r *NapkinVisualReconciler) updateLiveStatus(ctx context.Context, visual *napkinv1.NapkinVisual, mutate func(*napkinv1.NapkinVisual) error) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		latest := &napkinv1.NapkinVisual{}
+		if err := r.reader().Get(ctx, client.ObjectKeyFromObject(visual), latest); err != nil {
+			return err
+		}
+		if err := mutate(latest); err != nil {
+			return err
+		}
+		if err := r.Status().Update(ctx, latest); err != nil {
+			return err
+		}
+		visual.Status = latest.Status
+		visual.ResourceVersion = latest.ResourceVersion
+		return nil
+	})
+}
+
+// batchUpdateFailed converts a failed batch status write into a reconcile
+// result; losing the race to another reconcile just requeues
+func (r *NapkinVisualReconciler) batchUpdateFailed(ctx context.Context, err error) (ctrl.Result, error) {
+	if goerrors.Is(err, errAlreadySubmitted) {
+		log.FromContext(ctx).Info("Batch was updated by another reconcile")
+		return ctrl.Result{Requeue: true}, nil
+	}
+	return ctrl.Result{}, err
+}
+
+// reconcileBatchPolling polls every in-flight batch item and moves to
+// downloading once none are still being generated
+func (r *NapkinVisualReconciler) reconcileBatchPolling(ctx context.Context, visual *napkinv1.NapkinVisual) (ctrl.Result, error) {
+	ctx, span := r.tracer.Start(ctx, "reconcile_batch_polling")
+	defer span.End()
+	logger := log.FromContext(ctx)
+
+	apiKey, err := r.getAPIKey(ctx, visual)
+	if err != nil {
+		r.setFailedStatus(ctx, visual, fmt.Sprintf("Failed to read API key: %v", err))
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+	}
+
+	napkin := r.napkinClient(apiKey)
+	inFlight := false
+	estimatedSeconds := 0
+	for i := range visual.Status.BatchItems {
+		item := &visual.Status.BatchItems[i]
+		if item.Phase != phaseSubmitted && item.Phase != phaseProcessing {
+			continue
+		}
+
+		status, err := napkin.GetStatus(ctx, item.NapkinRequestId)
+		if err != nil {
+			logger.Error(err, "Failed to get batch item status", "batchIndex", item.Index)
+			inFlight = true
+			continue
+		}
+
+		switch status.Status {
+		case "completed":
+			for _, f := range status.Files {
+				visual.Status.GeneratedFiles = append(visual.Status.GeneratedFiles, napkinv1.GeneratedFileStatus{
+					Index:      f.Index,
+					BatchIndex: item.Index,
+					Format:     f.Format,
+					ColorMode:  f.ColorMode,
+					NapkinUrl:  f.URL,
+					SizeBytes:  f.SizeBytes,
+				})
+			}
+			item.Phase = phaseDownloading
+		case "failed":
+			item.Phase = phaseFailed
+			item.Error = fmt.Sprintf("Napkin generation failed: %s", status.Error)
+		case "processing":
+			item.Phase = phaseProcessing
+			inFlight = true
+		default:
+			inFlight = true
+		}
+		if status.EstimatedSeconds > 0 && (estimatedSeconds == 0 || status.EstimatedSeconds < estimatedSeconds) {
+			estimatedSeconds = status.EstimatedSeconds
+		}
+	}
+
+	if inFlight {
+		visual.Status.Phase = phaseProcessing
+		r.Status().Update(ctx, visual)
+		return ctrl.Result{RequeueAfter: r.pollInterval(estimatedSeconds)}, nil
+	}
+
+	if len(visual.Status.GeneratedFiles) == 0 {
+		r.setFailedStatus(ctx, visual, "All batch items failed to generate")
+		return ctrl.Result{RequeueAfter: 5 * time.Minute}, nil
+	}
+
+	visual.Status.Phase = phaseDownloading
+	r.Status().Update(ctx, visual)
+	return ctrl.Result{Requeue: true}, nil
+}
+
+// completeBatchItems marks downloaded batch items as completed and returns the
+// indexes of items that failed
+func completeBatchItems(visual *napkinv1.NapkinVisual) []int {
+	var failed []int
+	for i := range visual.Status.BatchItems {
+		item := &visual.Status.BatchItems[i]
+		switch item.Phase {
+		case phaseDownloading:
+			item.Phase = phaseCompleted
+		case phaseFailed:
+			failed = append(failed, item.Index)
+		}
+	}
+	return failed
+}
@@ -0,0 +1,234 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	napkinv1 "github.com/Tributary-ai-services/napkin-operator/api/v1"
+)
+
+// newBatchVisual returns a pending visual with one batch item per content
+func newBatchVisual(name string, contents ...string) *napkinv1.NapkinVisual {
+	visual := withPhase(newTestVisual(name), phasePending)
+	visual.Spec.Content = ""
+	visual.Spec.Batch = contents
+	return visual
+}
+
+func TestBatchPendingRetriesFailedSubmissions(t *testing.T) {
+	r, napkin, _ := newTestReconciler(t, newBatchVisual("batch", "first", "second", "third"))
+	napkin.submitStatus = func(n int) int {
+		if n == 1 {
+			return http.StatusServiceUnavailable
+		}
+		return 0
+	}
+
+	result := reconcileVisual(t, r, "batch")
+	visual := getVisual(t, r, "batch")
+	if visual.Status.Phase != phasePending || result.RequeueAfter != 30*time.Second {
+		t.Fatalf("expected the visual to wait in Pending for the failed item, got %s after %v", visual.Status.Phase, result.RequeueAfter)
+	}
+	items := visual.Status.BatchItems
+	if items[0].NapkinRequestId != "req-0" || items[2].NapkinRequestId != "req-2" {
+		t.Errorf("expected the accepted items to record their request IDs, got %+v", items)
+	}
+	if items[1].Phase != phasePending || items[1].SubmitAttempts != 1 || items[1].Error == "" {
+		t.Errorf("expected the rejected item to stay Pending with one attempt, got %+v", items[1])
+	}
+
+	reconcileVisual(t, r, "batch")
+	visual = getVisual(t, r, "batch")
+	if visual.Status.Phase != phaseSubmitted {
+		t.Fatalf("expected Submitted once every item was accepted, got %s", visual.Status.Phase)
+	}
+	if got := visual.Status.BatchItems[1]; got.Phase != phaseSubmitted || got.NapkinRequestId != "req-3" || got.Error != "" {
+		t.Errorf("expected the retried item to be submitted, got %+v", got)
+	}
+	if got := len(napkin.submitted()); got != 4 {
+		t.Errorf("expected only the failed item to be resubmitted, got %d submissions", got)
+	}
+}
+
+func TestBatchPendingFailsItemAfterMaxRetries(t *testing.T) {
+	visual := newBatchVisual("batch", "first", "second")
+	maxRetries := 2
+	visual.Spec.MaxRetries = &maxRetries
+	r, napkin, _ := newTestReconciler(t, visual)
+	napkin.submitStatus = func(n int) int {
+		if n > 0 {
+			return http.StatusInternalServerError
+		}
+		return 0
+	}
+
+	reconcileVisual(t, r, "batch")
+	reconcileVisual(t, r, "batch")
+
+	visual = getVisual(t, r, "batch")
+	if visual.Status.Phase != phaseSubmitted {
+		t.Fatalf("expected the remaining items to proceed, got %s", visual.Status.Phase)
+	}
+	if got := visual.Status.BatchItems[1]; got.Phase != phaseFailed || got.SubmitAttempts != 2 {
+		t.Errorf("expected the item to fail after two attempts, got %+v", got)
+	}
+	if got := visual.Status.BatchItems[0]; got.Phase != phaseSubmitted || got.NapkinRequestId != "req-0" {
+		t.Errorf("expected the first item to stay submitted, got %+v", got)
+	}
+}
+
+func TestBatchPendingFailsWhenNoItemIsSubmitted(t *testing.T) {
+	visual := newBatchVisual("batch", "first", "second")
+	maxRetries := 1
+	visual.Spec.MaxRetries = &maxRetries
+	r, napkin, _ := newTestReconciler(t, visual)
+	napkin.submitStatus = func(int) int { return http.StatusInternalServerError }
+
+	reconcileVisual(t, r, "batch")
+
+	if visual := getVisual(t, r, "batch"); visual.Status.Phase != phaseFailed {
+		t.Fatalf("expected Failed, got %s", visual.Status.Phase)
+	}
+}
+
+func TestBatchPendingPersistsEachRequestID(t *testing.T) {
+	r, napkin, _ := newTestReconciler(t, newBatchVisual("batch", "first", "second", "third"))
+	updates := 0
+	r.Client = interceptor.NewClient(r.Client.(client.WithWatch), interceptor.Funcs{
+		SubResourceUpdate: func(ctx context.Context, c client.Client, subResource string, obj client.Object, opts ...client.SubResourceUpdateOption) error {
+			updates++
+			if updates == 2 {
+				return fmt.Errorf("apiserver unavailable")
+			}
+			return c.SubResource(subResource).Update(ctx, obj, opts...)
+		},
+	})
+
+	_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Namespace: testNamespace, Name: "batch"}})
+	if err == nil {
+		t.Fatal("expected the failed status write to be returned")
+	}
+
+	visual := getVisual(t, r, "batch")
+	if got := visual.Status.BatchItems[0]; got.Phase != phaseSubmitted || got.NapkinRequestId != "req-0" {
+		t.Errorf("expected the first request ID to be persisted, got %+v", got)
+	}
+	if got := visual.Status.BatchItems[1]; got.Phase != phasePending || got.NapkinRequestId != "" {
+		t.Errorf("expected the unrecorded item to stay Pending, got %+v", got)
+	}
+	if got := len(napkin.submitted()); got != 2 {
+		t.Errorf("expected submission to stop at the failed write, got %d submissions", got)
+	}
+	if got := napkin.cancelled(); len(got) != 1 || got[0] != "req-1" {
+		t.Errorf("expected the untracked request to be cancelled, got %v", got)
+	}
+
+	// The next reconcile resubmits only the unrecorded items
+	reconcileVisual(t, r, "batch")
+	visual = getVisual(t, r, "batch")
+	if visual.Status.Phase != phaseSubmitted {
+		t.Fatalf("expected Submitted, got %s", visual.Status.Phase)
+	}
+	if got := len(napkin.submitted()); got != 4 {
+		t.Errorf("expected two more submissions, got %d", got)
+	}
+}
+
+func TestBatchPendingSkipsStaleCachedVisual(t *testing.T) {
+	r, napkin, _ := newTestReconciler(t, newBatchVisual("batch", "first", "second"))
+	live := r.Client.(client.WithWatch)
+	stale := getVisual(t, live, "batch")
+	// The cache still serves the visual as it was before the first pass
+	r.APIReader = live
+	r.Client = interceptor.NewClient(live, interceptor.Funcs{
+		Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+			if visual, ok := obj.(*napkinv1.NapkinVisual); ok && key.Name == "batch" {
+				stale.DeepCopyInto(visual)
+				return nil
+			}
+			return c.Get(ctx, key, obj, opts...)
+		},
+	})
+
+	reconcileVisual(t, r, "batch")
+	if phase := getVisual(t, live, "batch").Status.Phase; phase != phaseSubmitted {
+		t.Fatalf("expected Submitted after the first pass, got %s", phase)
+	}
+	result := reconcileVisual(t, r, "batch")
+
+	if got := len(napkin.submitted()); got != 2 {
+		t.Errorf("expected each item to be submitted once, got %d submissions", got)
+	}
+	if got := napkin.cancelled(); len(got) != 0 {
+		t.Errorf("expected no duplicate submissions to cancel, got %v", got)
+	}
+	if !result.Requeue {
+		t.Error("expected a requeue to pick up the live phase")
+	}
+}
+
+func TestBatchKeepsSpecDefaults(t *testing.T) {
+	// Without the defaulting webhook the stored spec has no defaults applied
+	visual := &napkinv1.NapkinVisual{
+		ObjectMeta: metav1.ObjectMeta{Name: "batch", Namespace: testNamespace, Finalizers: []string{finalizerName}},
+		Spec:       napkinv1.NapkinVisualSpec{Batch: []string{"first", "second"}, RegenerateOnChange: true},
+	}
+	r, napkin, _ := newTestReconciler(t, withPhase(visual, phasePending))
+	napkin.complete("req-0", napkin.addFile(0, "svg", "light", svgData))
+	napkin.complete("req-1", napkin.addFile(1, "svg", "light", svgData))
+	reconcileUntil(t, r, "batch", phaseCompleted)
+
+	submits := napkin.submitted()
+	if len(submits) != 2 {
+		t.Fatalf("expected one submission per item, got %d", len(submits))
+	}
+	for i, req := range submits {
+		if req.Format != napkinv1.DefaultFormat || req.ColorMode != napkinv1.DefaultColorMode || req.Variations != napkinv1.DefaultVariations {
+			t.Errorf("item %d submitted without spec defaults: %+v", i, req)
+		}
+	}
+
+	reconcileVisual(t, r, "batch")
+	if got := getVisual(t, r, "batch"); got.Status.Phase != phaseCompleted || readyReason(got) == "SpecChanged" {
+		t.Errorf("expected the unchanged visual to stay Completed, got %s (%s)", got.Status.Phase, readyReason(got))
+	}
+	if got := len(napkin.submitted()); got != 2 {
+		t.Errorf("expected no resubmission, got %d submissions", got)
+	}
+}
+
+func TestDeletingPartlySubmittedBatchCancelsItems(t *testing.T) {
+	r, napkin, _ := newTestReconciler(t, newBatchVisual("batch", "first", "second", "third"))
+	napkin.submitStatus = func(n int) int {
+		if n == 1 {
+			return http.StatusServiceUnavailable
+		}
+		return 0
+	}
+	reconcileVisual(t, r, "batch")
+	if phase := getVisual(t, r, "batch").Status.Phase; phase != phasePending {
+		t.Fatalf("expected the batch to wait in Pending, got %s", phase)
+	}
+
+	if err := r.Delete(context.Background(), getVisual(t, r, "batch")); err != nil {
+		t.Fatal(err)
+	}
+	reconcileVisual(t, r, "batch")
+
+	if got := napkin.cancelled(); len(got) != 2 || got[0] != "req-0" || got[1] != "req-2" {
+		t.Errorf("expected the submitted items to be cancelled, got %v", got)
+	}
+	var visual napkinv1.NapkinVisual
+	if err := r.Get(context.Background(), client.ObjectKey{Namespace: testNamespace, Name: "batch"}, &visual); err == nil {
+		t.Errorf("expected the finalizer to be removed, got %v", visual.Finalizers)
+	}
+}
@@ -0,0 +1,185 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	napkinv1 "github.com/Tributary-ai-services/napkin-operator/api/v1"
+)
+
+const (
+	// callbackAttempts bounds delivery attempts, one per reconcile, and
+	// callbackTimeout bounds how long an attempt may block a reconcile
+	callbackAttempts = 3
+	callbackTimeout  = 5 * time.Second
+
+	// callbackRetryDelay is multiplied by the attempt number between retries
+	callbackRetryDelay = 10 * time.Second
+)
+
+var (
+	publicCallbackClient = newCallbackClient(false)
+	anyCallbackClient    = newCallbackClient(true)
+)
+
+// newCallbackClient returns the HTTP client for callbacks. Unless
+// allowPrivate is set it refuses to connect to non-public addresses, checked
+// on every dial so DNS answers and redirects can't reach them either.
+func newCallbackClient(allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: callbackTimeout}
+	if !allowPrivate {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+				return fmt.Errorf("callback address %s is not a public address", host)
+			}
+			return nil
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: callbackTimeout, Transport: transport}
+}
+
+// sharedAddressSpace is the carrier-grade NAT range, which is not publicly routable
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// isPublicIP reports whether ip is a publicly routable unicast address
+func isPublicIP(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsUnspecified() &&
+		!ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() && !ip.IsMulticast() &&
+		!sharedAddressSpace.Contains(ip)
+}
+
+// callbackPayload is the JSON body POSTed to Spec.CallbackURL
+type callbackPayload struct {
+	Name      string   `json:"name"`
+	Namespace string   `json:"namespace"`
+	TenantId  string   `json:"tenantId"`
+	Phase     string   `json:"phase"`
+	Reason    string   `json:"reason,omitempty"`
+	Message   string   `json:"message,omitempty"`
+	Urls      []string `json:"urls,omitempty"`
+}
+
+// callbackPending reports whether the visual's callback still has to be delivered
+func callbackPending(visual *napkinv1.NapkinVisual) bool {
+	if visual.Spec.CallbackURL == "" || visual.Status.CallbackAttempts >= callbackAttempts {
+		return false
+	}
+	cond := findCondition(visual, "CallbackDelivered")
+	return cond == nil || cond.Status != "True"
+}
+
+// reconcileCallback makes one attempt to POST the visual's outcome to
+// Spec.CallbackURL and records the result as a CallbackDelivered condition.
+// It runs once the Completed or Failed status has been persisted, so the
+// receiver never sees an outcome the resource doesn't show.
+func (r *NapkinVisualReconciler) reconcileCallback(ctx context.Context, visual *napkinv1.NapkinVisual) (ctrl.Result, error) {
+	ctx, span := r.tracer.Start(ctx, "notify_callback")
+	defer span.End()
+	logger := log.FromContext(ctx)
+
+	payload := callbackPayload{
+		Name:      visual.Name,
+		Namespace: visual.Namespace,
+		TenantId:  visual.Spec.TenantId,
+		Phase:     visual.Status.Phase,
+	}
+	if ready := findCondition(visual, "Ready"); ready != nil {
+		payload.Reason = ready.Reason
+		payload.Message = ready.Message
+	}
+	for _, file := range visual.Status.GeneratedFiles {
+		if file.MinioUrl != "" {
+			payload.Urls = append(payload.Urls, file.MinioUrl)
+		}
+	}
+
+	httpClient := publicCallbackClient
+	if r.AllowPrivateCallbacks {
+		httpClient = anyCallbackClient
+	}
+	err := sendCallback(ctx, httpClient, visual.Spec.CallbackURL, payload)
+	visual.Status.CallbackAttempts++
+	cond := napkinv1.NapkinVisualCondition{
+		Type:               "CallbackDelivered",
+		Status:             "True",
+		LastTransitionTime: metav1.Now(),
+		Reason:             "Delivered",
+		Message:            "Notification delivered to " + redactURL(visual.Spec.CallbackURL),
+	}
+	if err != nil {
+		span.RecordError(err)
+		logger.Error(err, "Failed to deliver completion callback", "url", redactURL(visual.Spec.CallbackURL), "attempt", visual.Status.CallbackAttempts)
+		cond.Status = "False"
+		cond.Reason = "DeliveryFailed"
+		cond.Message = fmt.Sprintf("Callback to %s failed after %d of %d attempts: %v",
+			redactURL(visual.Spec.CallbackURL), visual.Status.CallbackAttempts, callbackAttempts, err)
+	}
+	setCondition(visual, cond)
+	if err := r.Status().Update(ctx, visual); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if callbackPending(visual) {
+		return ctrl.Result{RequeueAfter: time.Duration(visual.Status.CallbackAttempts) * callbackRetryDelay}, nil
+	}
+	return ctrl.Result{}, nil
+}
+
+// sendCallback POSTs the payload as JSON
+func sendCallback(ctx context.Context, httpClient *http.Client, callbackURL string, payload callbackPayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal callback payload: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, callbackURL, bytes.NewReader(body))
+	if err != nil {
+		// The URL itself may carry credentials, so don't echo the request error
+		return fmt.Errorf("invalid callback request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// redactURL hides any credentials in the URL's user info and query string
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "<invalid url>"
+	}
+	if u.RawQuery != "" {
+		u.RawQuery = "redacted"
+	}
+	return u.Redacted()
+}
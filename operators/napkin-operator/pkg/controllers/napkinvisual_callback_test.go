@@ -0,0 +1,209 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+// callbackReceiver records the payloads POSTed to it and answers with status
+type callbackReceiver struct {
+	*httptest.Server
+	mu       sync.Mutex
+	status   int
+	payloads []callbackPayload
+}
+
+func newCallbackReceiver(t *testing.T, status int) *callbackReceiver {
+	recv := &callbackReceiver{status: status}
+	recv.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var payload callbackPayload
+		if err := json.NewDecoder(req.Body).Decode(&payload); err != nil {
+			t.Errorf("decode callback: %v", err)
+		}
+		recv.mu.Lock()
+		recv.payloads = append(recv.payloads, payload)
+		recv.mu.Unlock()
+		w.WriteHeader(recv.status)
+	}))
+	t.Cleanup(recv.Close)
+	return recv
+}
+
+func (c *callbackReceiver) received() []callbackPayload {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]callbackPayload(nil), c.payloads...)
+}
+
+func TestCallbackIsDeliveredAfterCompletedStatusIsSaved(t *testing.T) {
+	recv := newCallbackReceiver(t, http.StatusNoContent)
+	r, napkin, _ := newTestReconciler(t)
+	r.AllowPrivateCallbacks = true
+	visual := downloadingVisual(napkin, "diagram", 1)
+	visual.Spec.CallbackURL = recv.URL + "/hook"
+	if err := r.Create(context.Background(), visual); err != nil {
+		t.Fatal(err)
+	}
+
+	reconcileVisual(t, r, "diagram")
+	if got := getVisual(t, r.Client, "diagram"); got.Status.Phase != phaseCompleted {
+		t.Fatalf("phase = %s, want %s", got.Status.Phase, phaseCompleted)
+	}
+	if n := len(recv.received()); n != 0 {
+		t.Fatalf("callback sent %d times before the Completed status was read back", n)
+	}
+
+	result := reconcileVisual(t, r, "diagram")
+	payloads := recv.received()
+	if len(payloads) != 1 {
+		t.Fatalf("callback sent %d times, want 1", len(payloads))
+	}
+	if payloads[0].Phase != phaseCompleted || payloads[0].Name != "diagram" || len(payloads[0].Urls) != 1 {
+		t.Errorf("unexpected payload %+v", payloads[0])
+	}
+	if result.RequeueAfter != 0 {
+		t.Errorf("RequeueAfter = %v after delivery, want 0", result.RequeueAfter)
+	}
+	got := getVisual(t, r.Client, "diagram")
+	if cond := findCondition(got, "CallbackDelivered"); cond == nil || cond.Status != "True" || cond.Reason != "Delivered" {
+		t.Errorf("CallbackDelivered condition = %+v", cond)
+	}
+
+	reconcileVisual(t, r, "diagram")
+	if n := len(recv.received()); n != 1 {
+		t.Errorf("callback sent %d times after another reconcile, want 1", n)
+	}
+}
+
+func TestCallbackFailureIsRetriedUpToAttemptLimit(t *testing.T) {
+	recv := newCallbackReceiver(t, http.StatusInternalServerError)
+	r, _, _ := newTestReconciler(t)
+	r.AllowPrivateCallbacks = true
+	visual := withPhase(newTestVisual("diagram"), phaseCompleted)
+	visual.Spec.CallbackURL = recv.URL
+	if err := r.Create(context.Background(), visual); err != nil {
+		t.Fatal(err)
+	}
+
+	for attempt := 1; attempt <= callbackAttempts; attempt++ {
+		result := reconcileVisual(t, r, "diagram")
+		if attempt < callbackAttempts && result.RequeueAfter == 0 {
+			t.Errorf("attempt %d: expected a delayed retry", attempt)
+		}
+		if attempt == callbackAttempts && result.RequeueAfter != 0 {
+			t.Errorf("attempt %d: RequeueAfter = %v, want no further retry", attempt, result.RequeueAfter)
+		}
+	}
+	reconcileVisual(t, r, "diagram")
+
+	if n := len(recv.received()); n != callbackAttempts {
+		t.Errorf("callback sent %d times, want %d", n, callbackAttempts)
+	}
+	got := getVisual(t, r.Client, "diagram")
+	if got.Status.CallbackAttempts != callbackAttempts {
+		t.Errorf("CallbackAttempts = %d, want %d", got.Status.CallbackAttempts, callbackAttempts)
+	}
+	if cond := findCondition(got, "CallbackDelivered"); cond == nil || cond.Status != "False" || cond.Reason != "DeliveryFailed" {
+		t.Errorf("CallbackDelivered condition = %+v", cond)
+	}
+}
+
+func TestCallbackToExhaustedFailureIsDelivered(t *testing.T) {
+	recv := newCallbackReceiver(t, http.StatusOK)
+	r, _, _ := newTestReconciler(t)
+	r.AllowPrivateCallbacks = true
+	visual := withPhase(newTestVisual("diagram"), phaseFailed)
+	visual.Spec.CallbackURL = recv.URL
+	visual.Status.RetryCount = maxRetries(visual)
+	if err := r.Create(context.Background(), visual); err != nil {
+		t.Fatal(err)
+	}
+
+	reconcileVisual(t, r, "diagram")
+
+	payloads := recv.received()
+	if len(payloads) != 1 || payloads[0].Phase != phaseFailed {
+		t.Errorf("payloads = %+v, want one Failed notification", payloads)
+	}
+}
+
+func TestCallbackToPrivateAddressIsRefused(t *testing.T) {
+	recv := newCallbackReceiver(t, http.StatusOK)
+	r, _, _ := newTestReconciler(t)
+	visual := withPhase(newTestVisual("diagram"), phaseCompleted)
+	visual.Spec.CallbackURL = recv.URL
+	if err := r.Create(context.Background(), visual); err != nil {
+		t.Fatal(err)
+	}
+
+	reconcileVisual(t, r, "diagram")
+
+	if n := len(recv.received()); n != 0 {
+		t.Errorf("callback to a loopback address was sent %d times", n)
+	}
+	got := getVisual(t, r.Client, "diagram")
+	if cond := findCondition(got, "CallbackDelivered"); cond == nil || cond.Reason != "DeliveryFailed" {
+		t.Errorf("CallbackDelivered condition = %+v", cond)
+	}
+}
+
+func TestCallbackIsNotSentWhenStatusWriteFails(t *testing.T) {
+	recv := newCallbackReceiver(t, http.StatusOK)
+	r, napkin, _ := newTestReconciler(t)
+	r.AllowPrivateCallbacks = true
+	visual := downloadingVisual(napkin, "diagram", 1)
+	visual.Spec.CallbackURL = recv.URL
+	if err := r.Create(context.Background(), visual); err != nil {
+		t.Fatal(err)
+	}
+	r.Client = interceptor.NewClient(r.Client.(client.WithWatch), interceptor.Funcs{
+		SubResourceUpdate: func(ctx context.Context, c client.Client, subResource string, obj client.Object, opts ...client.SubResourceUpdateOption) error {
+			return fmt.Errorf("apiserver unavailable")
+		},
+	})
+
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: testNamespace, Name: "diagram"}}
+	r.Reconcile(context.Background(), req)
+	r.Reconcile(context.Background(), req)
+
+	if n := len(recv.received()); n != 0 {
+		t.Errorf("callback sent %d times although the outcome was never saved", n)
+	}
+}
+
+func TestIsPublicIP(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.0.0.5", false},
+		{"172.16.3.4", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"224.0.0.1", false},
+	}
+	for _, tt := range tests {
+		if got := isPublicIP(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("isPublicIP(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"unicode/utf8"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	napkinv1 "github.com/Tributary-ai-services/napkin-operator/api/v1"
+)
+
+// contentFromIndex indexes NapkinVisuals by the ConfigMap their content is read from
+const contentFromIndex = "spec.contentFrom.name"
+
+// indexContentFrom returns the ConfigMap name for contentFromIndex
+func indexContentFrom(obj client.Object) []string {
+	visual, ok := obj.(*napkinv1.NapkinVisual)
+	if !ok || visual.Spec.ContentFrom == nil || visual.Spec.ContentFrom.Name == "" {
+		return nil
+	}
+	return []string{visual.Spec.ContentFrom.Name}
+}
+
+// resolveContent returns the visual's content, reading it from the referenced
+// ConfigMap when Spec.ContentFrom is set. Batch visuals ignore both.
+func (r *NapkinVisualReconciler) resolveContent(ctx context.Context, visual *napkinv1.NapkinVisual) (string, error) {
+	ref := visual.Spec.ContentFrom
+	if ref == nil || len(visual.Spec.Batch) > 0 {
+		return visual.Spec.Content, nil
+	}
+
+	// Read through the API server: only ConfigMap metadata is watched, so
+	// the cache doesn't hold every ConfigMap in the cluster
+	var cm corev1.ConfigMap
+	if err := r.reader().Get(ctx, types.NamespacedName{Namespace: visual.Namespace, Name: ref.Name}, &cm); err != nil {
+		return "", fmt.Errorf("failed to get ConfigMap %s: %w", ref.Name, err)
+	}
+	content, ok := cm.Data[ref.Key]
+	if !ok || content == "" {
+		return "", fmt.Errorf("key %q not found or empty in ConfigMap %s", ref.Key, ref.Name)
+	}
+	if n := utf8.RuneCountInString(content); n > napkinv1.MaxContentLength {
+		return "", fmt.Errorf("key %q in ConfigMap %s is %d characters, exceeding the limit of %d", ref.Key, ref.Name, n, napkinv1.MaxContentLength)
+	}
+	return content, nil
+}
+
+// specChanged reports whether the generation-relevant spec, including
+// ConfigMap-sourced content, differs from what was last submitted
+func (r *NapkinVisualReconciler) specChanged(ctx context.Context, visual *napkinv1.NapkinVisual) bool {
+	content, err := r.resolveContent(ctx, visual)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Failed to resolve content for change detection")
+		return false
+	}
+	return visual.Status.SpecHash != specHash(visual, content)
+}
+
+// visualsForConfigMap maps a ConfigMap to the visuals in its namespace that
+// read their content from it and regenerate on change
+func (r *NapkinVisualReconciler) visualsForConfigMap(ctx context.Context, obj client.Object) []reconcile.Request {
+	var visuals napkinv1.NapkinVisualList
+	if err := r.List(ctx, &visuals, client.InNamespace(obj.GetNamespace()), client.MatchingFields{contentFromIndex: obj.GetName()}); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to list NapkinVisuals for ConfigMap", "configMap", obj.GetName())
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, visual := range visuals.Items {
+		if visual.Spec.RegenerateOnChange {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Namespace: visual.Namespace, Name: visual.Name},
+			})
+		}
+	}
+	return requests
+}
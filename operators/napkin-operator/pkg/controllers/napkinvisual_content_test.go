@@ -0,0 +1,157 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	napkinv1 "github.com/Tributary-ai-services/napkin-operator/api/v1"
+)
+
+// contentConfigMap returns a ConfigMap holding content under the "text" key
+func contentConfigMap(name, content string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: testNamespace},
+		Data:       map[string]string{"text": content},
+	}
+}
+
+// configMapVisual returns a pending visual reading its content from the named ConfigMap
+func configMapVisual(name, configMap string) *napkinv1.NapkinVisual {
+	visual := withPhase(newTestVisual(name), phasePending)
+	visual.Spec.Content = ""
+	visual.Spec.ContentFrom = &napkinv1.ConfigMapKeyRef{Name: configMap, Key: "text"}
+	return visual
+}
+
+func TestContentFromConfigMapIsSubmitted(t *testing.T) {
+	r, napkin, _ := newTestReconciler(t,
+		contentConfigMap("architecture", "Gateway routes requests to services"),
+		configMapVisual("diagram", "architecture"))
+
+	reconcileVisual(t, r, "diagram")
+
+	submits := napkin.submitted()
+	if len(submits) != 1 || submits[0].Content != "Gateway routes requests to services" {
+		t.Errorf("submitted %+v, want the ConfigMap content", submits)
+	}
+	if got := getVisual(t, r, "diagram").Status.RenderedContent; got != "Gateway routes requests to services" {
+		t.Errorf("renderedContent = %q", got)
+	}
+}
+
+func TestContentFromMissingConfigMapKeyFails(t *testing.T) {
+	tests := []struct {
+		name    string
+		objs    []*corev1.ConfigMap
+		wantErr string
+	}{
+		{name: "missing ConfigMap", wantErr: "failed to get ConfigMap architecture"},
+		{name: "missing key", objs: []*corev1.ConfigMap{{ObjectMeta: metav1.ObjectMeta{Name: "architecture", Namespace: testNamespace}}}, wantErr: `key "text" not found or empty`},
+		{name: "empty key", objs: []*corev1.ConfigMap{contentConfigMap("architecture", "")}, wantErr: `key "text" not found or empty`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, napkin, _ := newTestReconciler(t, configMapVisual("diagram", "architecture"))
+			for _, cm := range tt.objs {
+				if err := r.Create(context.Background(), cm); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			reconcileVisual(t, r, "diagram")
+
+			visual := getVisual(t, r, "diagram")
+			if visual.Status.Phase != phaseFailed || !strings.Contains(visual.Status.Conditions[0].Message, tt.wantErr) {
+				t.Errorf("expected Failed with %q, got %s: %+v", tt.wantErr, visual.Status.Phase, visual.Status.Conditions)
+			}
+			if len(napkin.submitted()) != 0 {
+				t.Error("expected nothing to be submitted")
+			}
+		})
+	}
+}
+
+func TestContentFromConfigMapOverLimitFails(t *testing.T) {
+	r, napkin, _ := newTestReconciler(t,
+		contentConfigMap("architecture", strings.Repeat("x", napkinv1.MaxContentLength+1)),
+		configMapVisual("diagram", "architecture"))
+
+	reconcileVisual(t, r, "diagram")
+
+	visual := getVisual(t, r, "diagram")
+	if visual.Status.Phase != phaseFailed || !strings.Contains(visual.Status.Conditions[0].Message, "exceeding the limit of 50000") {
+		t.Errorf("expected Failed for over-length content, got %s: %+v", visual.Status.Phase, visual.Status.Conditions)
+	}
+	if len(napkin.submitted()) != 0 {
+		t.Error("expected nothing to be submitted")
+	}
+}
+
+func TestRenderedContentIsTruncatedInStatus(t *testing.T) {
+	content := strings.Repeat("Gateway routes requests. ", 2000)
+	r, napkin, _ := newTestReconciler(t,
+		contentConfigMap("architecture", content),
+		configMapVisual("diagram", "architecture"))
+
+	reconcileVisual(t, r, "diagram")
+
+	if submits := napkin.submitted(); len(submits) != 1 || submits[0].Content != content {
+		t.Fatalf("expected the full content to be submitted, got %d submissions", len(submits))
+	}
+	visual := getVisual(t, r, "diagram")
+	if got := len([]rune(visual.Status.RenderedContent)); got != maxRenderedContentLength {
+		t.Errorf("renderedContent is %d characters, want %d", got, maxRenderedContentLength)
+	}
+	if got := visual.Status.SubmittedRequest.ContentLength; got != len(content) {
+		t.Errorf("contentLength = %d, want %d", got, len(content))
+	}
+}
+
+func TestConfigMapEditRegeneratesVisual(t *testing.T) {
+	visual := configMapVisual("diagram", "architecture")
+	visual.Spec.RegenerateOnChange = true
+	r, napkin, _ := newTestReconciler(t, contentConfigMap("architecture", "Gateway routes requests"), visual)
+	napkin.complete("req-0", napkin.addFile(0, "svg", "light", svgData))
+	reconcileUntil(t, r, "diagram", phaseCompleted)
+
+	cm := contentConfigMap("architecture", "Gateway routes requests through a cache")
+	if err := r.Update(context.Background(), cm); err != nil {
+		t.Fatal(err)
+	}
+	reconcileVisual(t, r, "diagram")
+	if got := getVisual(t, r, "diagram"); got.Status.Phase != phasePending || readyReason(got) != "SpecChanged" {
+		t.Fatalf("expected regeneration, got %s (%s)", got.Status.Phase, readyReason(got))
+	}
+	reconcileVisual(t, r, "diagram")
+
+	if submits := napkin.submitted(); len(submits) != 2 || submits[1].Content != "Gateway routes requests through a cache" {
+		t.Errorf("expected the edited ConfigMap content to be submitted, got %+v", submits)
+	}
+}
+
+func TestVisualsForConfigMapUsesIndex(t *testing.T) {
+	watching := configMapVisual("watching", "architecture")
+	watching.Spec.RegenerateOnChange = true
+	static := configMapVisual("static", "architecture")
+	other := configMapVisual("other", "glossary")
+	other.Spec.RegenerateOnChange = true
+	inline := withPhase(newTestVisual("inline"), phasePending)
+	inline.Spec.RegenerateOnChange = true
+	elsewhere := configMapVisual("elsewhere", "architecture")
+	elsewhere.Namespace = "other-team"
+	elsewhere.Spec.RegenerateOnChange = true
+	r, _, _ := newTestReconciler(t, watching, static, other, inline, elsewhere)
+
+	// The metadata-only watch hands the mapper partial objects
+	requests := r.visualsForConfigMap(context.Background(), &metav1.PartialObjectMetadata{
+		ObjectMeta: metav1.ObjectMeta{Name: "architecture", Namespace: testNamespace},
+	})
+
+	if len(requests) != 1 || requests[0].Name != "watching" || requests[0].Namespace != testNamespace {
+		t.Errorf("requests = %v, want only %s/watching", requests, testNamespace)
+	}
+}
@@ -1,27 +1,44 @@
 package controllers
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	goerrors "errors"
 	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/errgroup"
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
 
 	napkinv1 "github.com/Tributary-ai-services/napkin-operator/api/v1"
 	minioclient "github.com/Tributary-ai-services/napkin-operator/pkg/minio"
 	napkinclient "github.com/Tributary-ai-services/napkin-operator/pkg/napkin"
+	"github.com/Tributary-ai-services/napkin-operator/pkg/storage"
 )
 
 const (
@@ -34,21 +51,106 @@ const (
 	phaseUploading   = "Uploading"
 	phaseCompleted   = "Completed"
 	phaseFailed      = "Failed"
+	phaseCancelled   = "Cancelled"
+
+	// cancelAnnotation requests cancellation of an in-flight generation
+	cancelAnnotation = "napkin.tas.ai/cancel"
+
+	// pausedAnnotation suspends reconciliation of a visual while set to "true"
+	pausedAnnotation = "napkin.tas.ai/paused"
+
+	// retryAnnotation forces a failed visual to be retried; each new value triggers one retry
+	retryAnnotation = "napkin.tas.ai/retry"
+
+	// defaultDownloadConcurrency bounds parallel file transfers per visual
+	defaultDownloadConcurrency = 4
+
+	// Polling intervals used while a generation is in progress
+	defaultPollInterval    = 5 * time.Second
+	minPollInterval        = 2 * time.Second
+	defaultMaxPollInterval = time.Minute
+
+	// defaultMaxRetries and defaultRetryDelay apply when the spec doesn't override them
+	defaultMaxRetries = 3
+	defaultRetryDelay = 5 * time.Minute
+
+	// defaultMaxStorageAttempts bounds how often storing files is retried while storage is unavailable
+	defaultMaxStorageAttempts = 10
+
+	// maxLastErrorLength keeps Status.LastError short enough for kubectl output;
+	// the full message is kept on the Ready condition
+	maxLastErrorLength = 120
+
+	// maxRenderedContentLength bounds Status.RenderedContent so long content
+	// doesn't bloat the status; SubmittedRequest records the full content's hash
+	maxRenderedContentLength = 1000
 )
 
+// clock returns the current time for start times and dated object keys;
+// tests replace it to render keys for a fixed date
+var clock = time.Now
+
 // NapkinVisualReconciler reconciles a NapkinVisual object
 type NapkinVisualReconciler struct {
 	client.Client
-	Scheme       *runtime.Scheme
-	tracer       trace.Tracer
-	NapkinURL    string
-	MinioClient  *minioclient.Client
+	Scheme    *runtime.Scheme
+	tracer    trace.Tracer
+	NapkinURL string
+	Storage   storage.Storage
+
+	// DownloadConcurrency limits concurrent download/upload of generated files
+	DownloadConcurrency int
+
+	// Recorder emits Kubernetes events for NapkinVisuals
+	Recorder record.EventRecorder
+
+	// APIReader reads NapkinVisuals bypassing the cache so a stale cached
+	// status cannot cause a duplicate submission; nil uses the client
+	APIReader client.Reader
+
+	// NapkinClients shares Napkin API clients across reconciles; nil creates a client per call
+	NapkinClients *napkinclient.ClientCache
+
+	// StyleCache caches available Napkin styles for StyleId validation; nil disables validation
+	StyleCache *napkinclient.StyleCache
+
+	// DefaultAPIKey is the operator-level Napkin API key used when no per-CR key is available
+	DefaultAPIKey string
+
+	// APIKeyDir is the directory spec.apiKeyFile is resolved in; files outside
+	// it are rejected, and empty disables key files
+	APIKeyDir string
+
+	// MaxPollInterval caps the polling delay derived from the Napkin completion estimate
+	MaxPollInterval time.Duration
+
+	// MaxInflightPerTenant caps the in-flight generations of a single tenant; 0 means unlimited
+	MaxInflightPerTenant int
+
+	// MaxStorageAttempts is the number of failed attempts to store generated
+	// files before a visual fails; 0 uses the default
+	MaxStorageAttempts int
+
+	// staged holds downloaded files awaiting a storage retry
+	staged stagedFiles
+
+	// tenants serializes per-tenant quota admission
+	tenants tenantLocks
+
+	// MaxConcurrentReconciles is the number of NapkinVisuals reconciled in parallel
+	MaxConcurrentReconciles int
+
+	// AllowPrivateCallbacks lets callbacks reach loopback, link-local and
+	// private network addresses, which are refused by default
+	AllowPrivateCallbacks bool
 }
 
 //+kubebuilder:rbac:groups=napkin.tas.ai,resources=napkinvisuals,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=napkin.tas.ai,resources=napkinvisuals/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=napkin.tas.ai,resources=napkinvisuals/finalizers,verbs=update
 //+kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
 // Reconcile implements the main reconciliation logic for NapkinVisual resources
 func (r *NapkinVisualReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
@@ -73,11 +175,30 @@ func (r *NapkinVisualReconciler) Reconcile(ctx context.Context, req ctrl.Request
 		return ctrl.Result{}, err
 	}
 
+	// Apply spec defaults in case the object was admitted without the defaulting
+	// webhook. The defaults are only used in memory; writes to the object go
+	// through patchFinalizers so they are never persisted by the controller.
+	original := visual.DeepCopy()
+	visual.SetDefaults()
+
+	// Leave paused visuals untouched apart from the Paused condition; deletion still proceeds
+	if visual.ObjectMeta.DeletionTimestamp.IsZero() {
+		paused := visual.Annotations[pausedAnnotation] == "true"
+		if paused != (findCondition(&visual, "Paused") != nil) {
+			return ctrl.Result{}, r.setPausedCondition(ctx, &visual, paused)
+		}
+		if paused {
+			logger.Info("Reconciliation paused via annotation")
+			return ctrl.Result{}, nil
+		}
+	}
+
 	// Handle finalizer for cleanup
 	if visual.ObjectMeta.DeletionTimestamp.IsZero() {
 		if !controllerutil.ContainsFinalizer(&visual, finalizerName) {
-			controllerutil.AddFinalizer(&visual, finalizerName)
-			return ctrl.Result{}, r.Update(ctx, &visual)
+			return ctrl.Result{}, r.patchFinalizers(ctx, original, func(obj client.Object) {
+				controllerutil.AddFinalizer(obj, finalizerName)
+			})
 		}
 	} else {
 		if controllerutil.ContainsFinalizer(&visual, finalizerName) {
@@ -85,8 +206,9 @@ func (r *NapkinVisualReconciler) Reconcile(ctx context.Context, req ctrl.Request
 				span.RecordError(err)
 				return ctrl.Result{}, err
 			}
-			controllerutil.RemoveFinalizer(&visual, finalizerName)
-			return ctrl.Result{}, r.Update(ctx, &visual)
+			return ctrl.Result{}, r.patchFinalizers(ctx, original, func(obj client.Object) {
+				controllerutil.RemoveFinalizer(obj, finalizerName)
+			})
 		}
 		return ctrl.Result{}, nil
 	}
@@ -94,7 +216,7 @@ func (r *NapkinVisualReconciler) Reconcile(ctx context.Context, req ctrl.Request
 	// Set initial status if needed
 	if visual.Status.Phase == "" {
 		visual.Status.Phase = phasePending
-		now := metav1.Now()
+		now := metav1.NewTime(clock())
 		visual.Status.StartTime = &now
 		visual.Status.Conditions = []napkinv1.NapkinVisualCondition{
 			{
@@ -112,24 +234,59 @@ func (r *NapkinVisualReconciler) Reconcile(ctx context.Context, req ctrl.Request
 		return ctrl.Result{Requeue: true}, nil
 	}
 
+	// Honor manual retry requests; the token is recorded so each value triggers once
+	if token := visual.Annotations[retryAnnotation]; token != "" && token != visual.Status.LastRetryToken {
+		if visual.Status.Phase == phaseFailed {
+			logger.Info("Manual retry requested", "token", token)
+			resetForRetry(&visual, "RetryRequested", "Manual retry requested via annotation")
+			visual.Status.RetryCount = 0
+			visual.Status.LastError = ""
+		}
+		visual.Status.LastRetryToken = token
+		if err := r.Status().Update(ctx, &visual); err != nil {
+			span.RecordError(err)
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{Requeue: true}, nil
+	}
+
+	// Honor cancellation requests for in-flight generations
+	if visual.Annotations[cancelAnnotation] == "true" && isActivePhase(visual.Status.Phase) {
+		return r.reconcileCancel(ctx, &visual)
+	}
+
 	// State machine reconciliation
 	switch visual.Status.Phase {
 	case phasePending:
+		defer r.admitTenant(&visual)()
+		if result, waiting := r.waitForTenantQuota(ctx, &visual); waiting {
+			return result, nil
+		}
+		if len(visual.Spec.Batch) > 0 {
+			return r.reconcileBatchPending(ctx, &visual)
+		}
 		return r.reconcilePending(ctx, &visual)
 	case phaseSubmitted, phaseProcessing:
+		if len(visual.Spec.Batch) > 0 {
+			return r.reconcileBatchPolling(ctx, &visual)
+		}
 		return r.reconcilePolling(ctx, &visual)
 	case phaseDownloading:
 		return r.reconcileDownloading(ctx, &visual)
 	case phaseUploading:
 		return r.reconcileUploading(ctx, &visual)
 	case phaseCompleted:
-		return ctrl.Result{}, nil
-	case phaseFailed:
-		// Auto-retry after 5 minutes if retries < 3
-		if visual.Status.RetryCount < 3 {
-			return ctrl.Result{RequeueAfter: 5 * time.Minute}, nil
+		if callbackPending(&visual) {
+			return r.reconcileCallback(ctx, &visual)
 		}
+		if visual.Spec.RegenerateOnChange && visual.Status.SpecHash != "" && r.specChanged(ctx, &visual) {
+			return r.reconcileRegenerate(ctx, &visual)
+		}
+		return ctrl.Result{}, nil
+	case phaseCancelled:
 		return ctrl.Result{}, nil
+	case phaseFailed:
+		return r.reconcileFailed(ctx, &visual)
 	default:
 		logger.Info("Unknown phase, resetting to Pending", "phase", visual.Status.Phase)
 		visual.Status.Phase = phasePending
@@ -138,43 +295,366 @@ func (r *NapkinVisualReconciler) Reconcile(ctx context.Context, req ctrl.Request
 	}
 }
 
+// patchFinalizers applies mutate to a copy of the visual as read from the API
+// server and patches only the resulting metadata change, so in-memory spec
+// defaults are not written back
+func (r *NapkinVisualReconciler) patchFinalizers(ctx context.Context, original *napkinv1.NapkinVisual, mutate func(client.Object)) error {
+	patched := original.DeepCopy()
+	mutate(patched)
+	return r.Patch(ctx, patched, client.MergeFromWithOptions(original, client.MergeFromWithOptimisticLock{}))
+}
+
+// reconcileFailed restarts a failed visual from Pending once the retry delay
+// has elapsed, until the retry limit is reached
+func (r *NapkinVisualReconciler) reconcileFailed(ctx context.Context, visual *napkinv1.NapkinVisual) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	if visual.Status.RetryCount >= maxRetries(visual) {
+		if callbackPending(visual) {
+			return r.reconcileCallback(ctx, visual)
+		}
+		return ctrl.Result{}, nil
+	}
+
+	delay := retryDelay(visual)
+	if cond := findCondition(visual, "Ready"); cond != nil {
+		if remaining := delay - time.Since(cond.LastTransitionTime.Time); remaining > 0 {
+			return ctrl.Result{RequeueAfter: remaining}, nil
+		}
+	}
+
+	logger.Info("Retrying failed NapkinVisual", "retryCount", visual.Status.RetryCount)
+	resetForRetry(visual, "Retrying", fmt.Sprintf("Retrying after failure (attempt %d)", visual.Status.RetryCount+1))
+	if err := r.Status().Update(ctx, visual); err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{Requeue: true}, nil
+}
+
+// reconcileRegenerate discards the previous results of a completed visual whose
+// generation-relevant spec changed and starts over from Pending
+func (r *NapkinVisualReconciler) reconcileRegenerate(ctx context.Context, visual *napkinv1.NapkinVisual) (ctrl.Result, error) {
+	ctx, span := r.tracer.Start(ctx, "reconcile_regenerate")
+	defer span.End()
+	logger := log.FromContext(ctx)
+
+	logger.Info("Spec changed, regenerating visual")
+	r.deleteStoredFiles(ctx, visual)
+	resetForRetry(visual, "SpecChanged", "Spec changed, regenerating visual")
+	visual.Status.RetryCount = 0
+	visual.Status.LastError = ""
+	visual.Status.SpecHash = ""
+	if err := r.Status().Update(ctx, visual); err != nil {
+		span.RecordError(err)
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{Requeue: true}, nil
+}
+
 // reconcilePending reads the API key and submits the visual generation request
 func (r *NapkinVisualReconciler) reconcilePending(ctx context.Context, visual *napkinv1.NapkinVisual) (ctrl.Result, error) {
 	ctx, span := r.tracer.Start(ctx, "reconcile_pending")
 	defer span.End()
 	logger := log.FromContext(ctx)
 
-	// Read API key from Secret
+	// Resolve the API key
 	apiKey, err := r.getAPIKey(ctx, visual)
 	if err != nil {
 		r.setFailedStatus(ctx, visual, fmt.Sprintf("Failed to read API key: %v", err))
 		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
 	}
 
+	rawContent, err := r.resolveContent(ctx, visual)
+	if err != nil {
+		r.setFailedStatus(ctx, visual, fmt.Sprintf("Failed to read content: %v", err))
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+	}
+
+	// Substitute spec variables into the content and context
+	content, err := napkinv1.RenderTemplate("content", rawContent, visual.Spec.Variables)
+	if err != nil {
+		r.setFailedStatus(ctx, visual, fmt.Sprintf("Failed to render content: %v", err))
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+	}
+	genContext, err := napkinv1.RenderTemplate("context", visual.Spec.Context, visual.Spec.Variables)
+	if err != nil {
+		r.setFailedStatus(ctx, visual, fmt.Sprintf("Failed to render context: %v", err))
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+	}
+
+	submitReq := buildSubmitRequest(visual, content, genContext)
+	visual.Status.RequestHash = ""
+	if visual.Spec.Deduplicate {
+		visual.Status.RequestHash = requestHash(submitReq)
+		files, err := r.findDeduplicatedFiles(ctx, visual)
+		if err != nil {
+			// Fall back to generating the visual
+			logger.Error(err, "Failed to look up previously generated files", "requestHash", visual.Status.RequestHash)
+		} else if len(files) > 0 {
+			logger.Info("Reusing previously generated files", "requestHash", visual.Status.RequestHash, "files", len(files))
+			visual.Status.RenderedContent = truncateMessage(content, maxRenderedContentLength)
+			visual.Status.SubmittedRequest = summarizeRequest(submitReq)
+			visual.Status.SpecHash = specHash(visual, rawContent)
+			visual.Status.GeneratedFiles = files
+			r.setCompletedStatus(ctx, visual, "True", "Deduplicated", "Reused visuals previously generated from an identical request")
+			return ctrl.Result{}, nil
+		}
+	}
+
+	// A requeue can race the status write of an earlier submission, so check
+	// the live object rather than the cached one before submitting again
+	submitted, err := r.alreadySubmitted(ctx, visual)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if submitted {
+		logger.Info("Skipping submission; visual was already submitted")
+		return ctrl.Result{Requeue: true}, nil
+	}
+
 	// Create Napkin client and submit
-	napkin := napkinclient.NewClient(r.NapkinURL, apiKey)
-	resp, err := napkin.Submit(ctx, &napkinclient.SubmitRequest{
-		Content:    visual.Spec.Content,
-		Format:     visual.Spec.Format,
-		StyleId:    visual.Spec.Style.StyleId,
-		ColorMode:  visual.Spec.Style.ColorMode,
-		Language:   visual.Spec.Language,
-		Variations: visual.Spec.Variations,
-		Context:    visual.Spec.Context,
-	})
+	napkin := r.napkinClient(apiKey)
+	r.validateStyle(ctx, visual, napkin)
+	resp, err := napkin.Submit(ctx, submitReq)
 	if err != nil {
 		logger.Error(err, "Failed to submit visual generation")
 		r.setFailedStatus(ctx, visual, fmt.Sprintf("Failed to submit: %v", err))
 		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
 	}
 
-	visual.Status.Phase = phaseSubmitted
-	visual.Status.NapkinRequestId = resp.ID
-	r.Status().Update(ctx, visual)
+	summary := summarizeRequest(submitReq)
+	hash := specHash(visual, rawContent)
+	reqHash := visual.Status.RequestHash
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if err := r.reader().Get(ctx, client.ObjectKeyFromObject(visual), visual); err != nil {
+			return err
+		}
+		if visual.Status.NapkinRequestId != "" || visual.Status.Phase != phasePending {
+			return errAlreadySubmitted
+		}
+		visual.Status.Phase = phaseSubmitted
+		visual.Status.NapkinRequestId = resp.ID
+		visual.Status.RenderedContent = truncateMessage(content, maxRenderedContentLength)
+		visual.Status.SubmittedRequest = summary
+		visual.Status.RequestHash = reqHash
+		visual.Status.SpecHash = hash
+		removeCondition(visual, "QuotaExceeded")
+		return r.Status().Update(ctx, visual)
+	})
+	if err != nil {
+		// The job can't be tracked, so cancel it rather than leave it orphaned
+		if cerr := napkin.Cancel(ctx, resp.ID); cerr != nil {
+			logger.Error(cerr, "Failed to cancel untracked Napkin request", "requestId", resp.ID)
+		}
+		if goerrors.Is(err, errAlreadySubmitted) {
+			logger.Info("Cancelled duplicate submission", "requestId", resp.ID)
+			return ctrl.Result{Requeue: true}, nil
+		}
+		return ctrl.Result{}, err
+	}
 
 	return ctrl.Result{RequeueAfter: 5 * time.Second}, nil
 }
 
+// errAlreadySubmitted reports that another reconcile recorded a submission first
+var errAlreadySubmitted = goerrors.New("visual was already submitted")
+
+// reader returns the uncached reader, falling back to the client
+func (r *NapkinVisualReconciler) reader() client.Reader {
+	if r.APIReader != nil {
+		return r.APIReader
+	}
+	return r.Client
+}
+
+// alreadySubmitted reports whether the live visual has left Pending or
+// already records a Napkin request
+func (r *NapkinVisualReconciler) alreadySubmitted(ctx context.Context, visual *napkinv1.NapkinVisual) (bool, error) {
+	latest := &napkinv1.NapkinVisual{}
+	if err := r.reader().Get(ctx, client.ObjectKeyFromObject(visual), latest); err != nil {
+		return false, err
+	}
+	return latest.Status.NapkinRequestId != "" || latest.Status.Phase != phasePending, nil
+}
+
+// validateStyle emits a warning event when Style.StyleId is not one of the
+// styles available to the API key. Validation is skipped if the styles
+// endpoint is unavailable; the visual then carries a StylesUnavailable
+// condition until a later validation succeeds.
+func (r *NapkinVisualReconciler) validateStyle(ctx context.Context, visual *napkinv1.NapkinVisual, napkin *napkinclient.Client) {
+	styleID := visual.Spec.Style.StyleId
+	if styleID == "" || r.StyleCache == nil {
+		return
+	}
+	logger := log.FromContext(ctx)
+
+	styles, err := r.StyleCache.Styles(ctx, napkin)
+	if err != nil {
+		logger.V(1).Info("Skipping style validation, styles unavailable", "error", err.Error())
+		if findCondition(visual, "StylesUnavailable") == nil {
+			setCondition(visual, napkinv1.NapkinVisualCondition{
+				Type:               "StylesUnavailable",
+				Status:             "True",
+				LastTransitionTime: metav1.Now(),
+				Reason:             "StyleListFailed",
+				Message:            fmt.Sprintf("Style %q was not validated, Napkin styles are unavailable: %v", styleID, err),
+			})
+			if err := r.Status().Update(ctx, visual); err != nil {
+				logger.Error(err, "Failed to update status")
+			}
+		}
+		return
+	}
+	if findCondition(visual, "StylesUnavailable") != nil {
+		removeCondition(visual, "StylesUnavailable")
+		if err := r.Status().Update(ctx, visual); err != nil {
+			logger.Error(err, "Failed to update status")
+		}
+	}
+
+	for _, style := range styles {
+		if style.ID == styleID {
+			return
+		}
+	}
+
+	if r.Recorder != nil {
+		r.Recorder.Eventf(visual, corev1.EventTypeWarning, "UnknownStyle", "Style %q is not in the list of available Napkin styles", styleID)
+	}
+}
+
+// buildSubmitRequest builds the Napkin submit request for the given rendered content
+func buildSubmitRequest(visual *napkinv1.NapkinVisual, content, genContext string) *napkinclient.SubmitRequest {
+	// "auto" lets Napkin choose, which is also what an omitted orientation means
+	orientation := visual.Spec.Style.Orientation
+	if orientation == "auto" {
+		orientation = ""
+	}
+
+	return &napkinclient.SubmitRequest{
+		Content:     content,
+		Format:      visual.Spec.Format,
+		StyleId:     visual.Spec.Style.StyleId,
+		ColorMode:   visual.Spec.Style.ColorMode,
+		Orientation: orientation,
+		Language:    visual.Spec.Language,
+		Variations:  visual.Spec.Variations,
+		Width:       visual.Spec.Width,
+		Height:      visual.Spec.Height,
+		DPI:         visual.Spec.DPI,
+		Context:     genContext,
+	}
+}
+
+// summarizeRequest records what was sent to Napkin without storing the content twice
+func summarizeRequest(req *napkinclient.SubmitRequest) *napkinv1.SubmittedRequestStatus {
+	sum := sha256.Sum256([]byte(req.Content))
+	return &napkinv1.SubmittedRequestStatus{
+		ContentSha256: hex.EncodeToString(sum[:]),
+		ContentLength: len(req.Content),
+		Format:        req.Format,
+		StyleId:       req.StyleId,
+		ColorMode:     req.ColorMode,
+		Orientation:   req.Orientation,
+		Language:      req.Language,
+		Variations:    req.Variations,
+		Width:         req.Width,
+		Height:        req.Height,
+		DPI:           req.DPI,
+		ContextLength: len(req.Context),
+	}
+}
+
+// requestHash identifies a Napkin request by everything that affects its output
+func requestHash(req *napkinclient.SubmitRequest) string {
+	data, _ := json.Marshal(req)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// dedupManifestName is written next to a deduplicated file set once every
+// file has been stored; sets without it are incomplete and never reused
+const dedupManifestName = "manifest.json"
+
+// dedupManifestFile describes one stored file of a deduplicated set
+type dedupManifestFile struct {
+	Index     int    `json:"index"`
+	Format    string `json:"format"`
+	ColorMode string `json:"colorMode,omitempty"`
+	Key       string `json:"key"`
+	SizeBytes int64  `json:"sizeBytes,omitempty"`
+}
+
+// writeDedupManifest records the visual's stored files under its request hash
+func (r *NapkinVisualReconciler) writeDedupManifest(ctx context.Context, visual *napkinv1.NapkinVisual, bucket string) error {
+	var files []dedupManifestFile
+	for _, file := range visual.Status.GeneratedFiles {
+		if file.MinioKey == "" {
+			continue
+		}
+		files = append(files, dedupManifestFile{
+			Index:     file.Index,
+			Format:    file.Format,
+			ColorMode: file.ColorMode,
+			Key:       file.MinioKey,
+			SizeBytes: file.SizeBytes,
+		})
+	}
+	data, err := json.Marshal(files)
+	if err != nil {
+		return err
+	}
+	key := dedupDir(visual) + "/" + dedupManifestName
+	if _, err := r.Storage.UploadWithTags(ctx, bucket, key, data, "application/json", map[string]string{"tenant": visual.Spec.TenantId}); err != nil {
+		return fmt.Errorf("failed to write deduplication manifest: %w", err)
+	}
+	return nil
+}
+
+// findDeduplicatedFiles returns the files already stored for the visual's
+// request hash, or none if no complete set has been stored for it
+func (r *NapkinVisualReconciler) findDeduplicatedFiles(ctx context.Context, visual *napkinv1.NapkinVisual) ([]napkinv1.GeneratedFileStatus, error) {
+	bucket := bucketName(visual)
+	dir := dedupDir(visual) + "/"
+	objects, err := r.Storage.List(ctx, bucket, dir)
+	if err != nil {
+		return nil, err
+	}
+	stored := make(map[string]bool, len(objects))
+	for _, obj := range objects {
+		stored[obj.Key] = true
+	}
+	if !stored[dir+dedupManifestName] {
+		return nil, nil
+	}
+
+	data, err := r.Storage.Download(ctx, bucket, dir+dedupManifestName)
+	if err != nil {
+		return nil, err
+	}
+	var manifest []dedupManifestFile
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid deduplication manifest: %w", err)
+	}
+
+	var files []napkinv1.GeneratedFileStatus
+	for _, entry := range manifest {
+		// Objects may have expired since the set was stored
+		if !stored[entry.Key] {
+			return nil, nil
+		}
+		files = append(files, napkinv1.GeneratedFileStatus{
+			Index:     entry.Index,
+			Format:    entry.Format,
+			ColorMode: entry.ColorMode,
+			MinioKey:  entry.Key,
+			MinioUrl:  r.Storage.ObjectURL(bucket, entry.Key),
+			SizeBytes: entry.SizeBytes,
+		})
+	}
+	return files, nil
+}
+
 // reconcilePolling polls the Napkin API for status
 func (r *NapkinVisualReconciler) reconcilePolling(ctx context.Context, visual *napkinv1.NapkinVisual) (ctrl.Result, error) {
 	ctx, span := r.tracer.Start(ctx, "reconcile_polling")
@@ -187,7 +667,7 @@ func (r *NapkinVisualReconciler) reconcilePolling(ctx context.Context, visual *n
 		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
 	}
 
-	napkin := napkinclient.NewClient(r.NapkinURL, apiKey)
+	napkin := r.napkinClient(apiKey)
 	status, err := napkin.GetStatus(ctx, visual.Status.NapkinRequestId)
 	if err != nil {
 		logger.Error(err, "Failed to get visual status")
@@ -219,17 +699,54 @@ func (r *NapkinVisualReconciler) reconcilePolling(ctx context.Context, visual *n
 	case "processing":
 		visual.Status.Phase = phaseProcessing
 		r.Status().Update(ctx, visual)
-		return ctrl.Result{RequeueAfter: 5 * time.Second}, nil
+		return ctrl.Result{RequeueAfter: r.pollInterval(status.EstimatedSeconds)}, nil
 
 	default:
-		return ctrl.Result{RequeueAfter: 5 * time.Second}, nil
+		return ctrl.Result{RequeueAfter: r.pollInterval(status.EstimatedSeconds)}, nil
+	}
+}
+
+// pollInterval returns how long to wait before polling again. Without an ETA
+// the default interval is used; otherwise the ETA is clamped between
+// minPollInterval and the configured maximum.
+func (r *NapkinVisualReconciler) pollInterval(estimatedSeconds int) time.Duration {
+	if estimatedSeconds <= 0 {
+		return defaultPollInterval
+	}
+
+	maxInterval := r.MaxPollInterval
+	if maxInterval <= 0 {
+		maxInterval = defaultMaxPollInterval
+	}
+
+	interval := time.Duration(estimatedSeconds) * time.Second
+	if interval < minPollInterval {
+		return minPollInterval
 	}
+	if interval > maxInterval {
+		return maxInterval
+	}
+	return interval
 }
 
-// reconcileDownloading downloads files from Napkin URLs
+// reconcileDownloading downloads files from Napkin URLs and stores them
 func (r *NapkinVisualReconciler) reconcileDownloading(ctx context.Context, visual *napkinv1.NapkinVisual) (ctrl.Result, error) {
 	ctx, span := r.tracer.Start(ctx, "reconcile_downloading")
 	defer span.End()
+	return r.transferFiles(ctx, visual)
+}
+
+// reconcileUploading retries storing files after storage was unavailable,
+// reusing the files staged by the earlier attempt
+func (r *NapkinVisualReconciler) reconcileUploading(ctx context.Context, visual *napkinv1.NapkinVisual) (ctrl.Result, error) {
+	ctx, span := r.tracer.Start(ctx, "reconcile_uploading")
+	defer span.End()
+	return r.transferFiles(ctx, visual)
+}
+
+// transferFiles downloads the generated files that aren't stored yet and
+// uploads them to storage
+func (r *NapkinVisualReconciler) transferFiles(ctx context.Context, visual *napkinv1.NapkinVisual) (ctrl.Result, error) {
 	logger := log.FromContext(ctx)
 
 	apiKey, err := r.getAPIKey(ctx, visual)
@@ -238,84 +755,324 @@ func (r *NapkinVisualReconciler) reconcileDownloading(ctx context.Context, visua
 		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
 	}
 
-	napkin := napkinclient.NewClient(r.NapkinURL, apiKey)
+	napkin := r.napkinClient(apiKey)
+
+	bucket := bucketName(visual)
+	if err := r.Storage.EnsureBucket(ctx, bucket, visual.Spec.Storage.ExpireAfterDays); err != nil {
+		logger.Error(err, "Failed to prepare storage bucket", "bucket", bucket)
+		err = fmt.Errorf("failed to prepare bucket %s: %w", bucket, err)
+		if storage.IsTransient(err) {
+			// Nothing was downloaded or staged yet, so retry from the current phase
+			return r.storageUnavailable(ctx, visual, visual.Status.Phase, err)
+		}
+		r.setFailedStatus(ctx, visual, err.Error())
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+	}
+
+	concurrency := r.DownloadConcurrency
+	if concurrency <= 0 {
+		concurrency = defaultDownloadConcurrency
+	}
 
-	// Download all files and transition to uploading
-	for i, file := range visual.Status.GeneratedFiles {
-		if file.NapkinUrl == "" {
+	// Download all files and upload them to storage using a bounded worker pool.
+	// Each worker only writes to its own GeneratedFiles element and error slot,
+	// so a failed file doesn't stop the others.
+	errs := make([]error, len(visual.Status.GeneratedFiles))
+	var g errgroup.Group
+	g.SetLimit(concurrency)
+	for i := range visual.Status.GeneratedFiles {
+		file := &visual.Status.GeneratedFiles[i]
+		if file.NapkinUrl == "" || file.MinioKey != "" {
 			continue
 		}
-		data, err := napkin.DownloadFile(ctx, file.NapkinUrl)
-		if err != nil {
-			logger.Error(err, "Failed to download file", "index", file.Index)
-			r.setFailedStatus(ctx, visual, fmt.Sprintf("Failed to download file %d: %v", file.Index, err))
+		g.Go(func() error {
+			errs[i] = r.transferFile(ctx, napkin, visual, bucket, i)
+			return nil
+		})
+	}
+	g.Wait()
+	if err := goerrors.Join(errs...); err != nil {
+		if onlyTransientStorageErrors(errs) {
+			return r.storageUnavailable(ctx, visual, phaseUploading, err)
+		}
+		r.staged.clear(visual.UID)
+		r.setFailedStatus(ctx, visual, err.Error())
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+	}
+	r.staged.clear(visual.UID)
+
+	if visual.Spec.Deduplicate && visual.Status.RequestHash != "" {
+		if err := r.writeDedupManifest(ctx, visual, bucket); err != nil {
+			if storage.IsTransient(err) {
+				return r.storageUnavailable(ctx, visual, phaseUploading, err)
+			}
+			r.setFailedStatus(ctx, visual, err.Error())
 			return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
 		}
+	}
+
+	// All files uploaded, mark completed
+	readyStatus, reason, message := "True", "Completed", "All visuals generated and stored"
+	if failed := completeBatchItems(visual); len(failed) > 0 {
+		readyStatus, reason = "False", "PartiallyCompleted"
+		message = fmt.Sprintf("Batch items %v failed; remaining visuals stored", failed)
+		visual.Status.LastError = truncateMessage(message, maxLastErrorLength)
+	}
 
-		// Upload to MinIO
-		bucket := visual.Spec.Storage.Bucket
-		if bucket == "" {
-			bucket = "napkin-visuals"
+	r.setCompletedStatus(ctx, visual, readyStatus, reason, message)
+	return ctrl.Result{}, nil
+}
+
+// transferFile downloads the i-th generated file, or takes it from the
+// staging area, and uploads it to storage. Files whose upload failed
+// transiently are staged for the next attempt.
+func (r *NapkinVisualReconciler) transferFile(ctx context.Context, napkin *napkinclient.Client, visual *napkinv1.NapkinVisual, bucket string, i int) error {
+	logger := log.FromContext(ctx)
+	file := &visual.Status.GeneratedFiles[i]
+
+	data, ok := r.staged.get(visual.UID, i)
+	if !ok {
+		var err error
+		data, err = napkin.DownloadFile(ctx, file.NapkinUrl)
+		if err != nil {
+			logger.Error(err, "Failed to download file", "index", file.Index)
+			return fmt.Errorf("failed to download file %d: %w", file.Index, err)
 		}
-		prefix := visual.Spec.Storage.Prefix
-		tenantId := visual.Spec.TenantId
-		if tenantId == "" {
-			tenantId = "default"
+		if err := validateFileContent(file.Format, data); err != nil {
+			logger.Error(err, "Downloaded file failed validation", "index", file.Index)
+			return fmt.Errorf("invalid content for file %d: %w", file.Index, err)
 		}
+	}
 
-		key := fmt.Sprintf("%s%s/%s/%d.%s", prefix, tenantId, visual.Name, file.Index, file.Format)
-		contentType := getContentType(file.Format)
+	key, err := objectKey(visual, file)
+	if err != nil {
+		return err
+	}
+	contentType := getContentType(file.Format, data)
 
-		url, err := r.MinioClient.Upload(ctx, bucket, key, data, contentType)
-		if err != nil {
-			logger.Error(err, "Failed to upload to MinIO", "key", key)
-			r.setFailedStatus(ctx, visual, fmt.Sprintf("Failed to upload file %d to MinIO: %v", file.Index, err))
-			return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+	url, err := r.Storage.UploadWithTags(ctx, bucket, key, data, contentType, objectTags(visual, file))
+	if err != nil {
+		logger.Error(err, "Failed to upload to storage", "key", key)
+		if storage.IsTransient(err) {
+			r.staged.put(visual.UID, i, data)
 		}
+		return fmt.Errorf("failed to upload file %d to storage: %w", file.Index, err)
+	}
 
-		visual.Status.GeneratedFiles[i].MinioKey = key
-		visual.Status.GeneratedFiles[i].MinioUrl = url
-		visual.Status.GeneratedFiles[i].SizeBytes = int64(len(data))
+	file.MinioKey = key
+	file.MinioUrl = url
+	file.SizeBytes = int64(len(data))
+	return nil
+}
+
+// onlyTransientStorageErrors reports whether every failure in errs is a
+// transient storage error, which is retried in the Uploading phase without
+// consuming a generation retry
+func onlyTransientStorageErrors(errs []error) bool {
+	for _, err := range errs {
+		if err != nil && !storage.IsTransient(err) {
+			return false
+		}
 	}
+	return true
+}
 
-	// All files uploaded, mark completed
+// storageUnavailable moves the visual to phase to retry storing its files
+// later, and fails it once MaxStorageAttempts is reached
+func (r *NapkinVisualReconciler) storageUnavailable(ctx context.Context, visual *napkinv1.NapkinVisual, phase string, err error) (ctrl.Result, error) {
+	visual.Status.StorageAttempts++
+	if visual.Status.StorageAttempts >= r.maxStorageAttempts() {
+		r.staged.clear(visual.UID)
+		r.setFailedStatus(ctx, visual, fmt.Sprintf("Storage unavailable after %d attempts: %v", visual.Status.StorageAttempts, err))
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+	}
+
+	message := fmt.Sprintf("Storage unavailable (attempt %d of %d): %v", visual.Status.StorageAttempts, r.maxStorageAttempts(), err)
+	visual.Status.Phase = phase
+	visual.Status.LastError = truncateMessage(message, maxLastErrorLength)
+	visual.Status.Conditions = []napkinv1.NapkinVisualCondition{
+		{
+			Type:               "Ready",
+			Status:             "False",
+			LastTransitionTime: metav1.Now(),
+			Reason:             "StorageUnavailable",
+			Message:            message,
+		},
+	}
+	r.Status().Update(ctx, visual)
+	return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+}
+
+// maxStorageAttempts returns the number of storage failures after which a visual fails
+func (r *NapkinVisualReconciler) maxStorageAttempts() int {
+	if r.MaxStorageAttempts > 0 {
+		return r.MaxStorageAttempts
+	}
+	return defaultMaxStorageAttempts
+}
+
+// setCompletedStatus marks the visual Completed
+func (r *NapkinVisualReconciler) setCompletedStatus(ctx context.Context, visual *napkinv1.NapkinVisual, readyStatus, reason, message string) {
 	now := metav1.Now()
 	visual.Status.Phase = phaseCompleted
+	visual.Status.PrimaryUrl = primaryURL(visual.Status.GeneratedFiles)
 	visual.Status.CompletionTime = &now
 	visual.Status.Conditions = []napkinv1.NapkinVisualCondition{
 		{
 			Type:               "Ready",
-			Status:             "True",
+			Status:             readyStatus,
 			LastTransitionTime: now,
-			Reason:             "Completed",
-			Message:            "All visuals generated and stored in MinIO",
+			Reason:             reason,
+			Message:            message,
 		},
 	}
 	visual.Status.ObservedGeneration = visual.Generation
 	r.Status().Update(ctx, visual)
-
-	return ctrl.Result{}, nil
 }
 
-// reconcileUploading handles the uploading phase (used if download and upload are separated)
-func (r *NapkinVisualReconciler) reconcileUploading(ctx context.Context, visual *napkinv1.NapkinVisual) (ctrl.Result, error) {
-	// In this implementation, download and upload happen together in reconcileDownloading
-	visual.Status.Phase = phaseCompleted
+// reconcileCancel cancels the Napkin-side job and moves the visual to Cancelled
+func (r *NapkinVisualReconciler) reconcileCancel(ctx context.Context, visual *napkinv1.NapkinVisual) (ctrl.Result, error) {
+	ctx, span := r.tracer.Start(ctx, "reconcile_cancel")
+	defer span.End()
+	logger := log.FromContext(ctx)
+
+	if err := r.cancelGeneration(ctx, visual); err != nil {
+		span.RecordError(err)
+		logger.Error(err, "Failed to cancel visual generation", "requestId", visual.Status.NapkinRequestId)
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+	}
+
+	now := metav1.Now()
+	visual.Status.Phase = phaseCancelled
+	visual.Status.CompletionTime = &now
+	visual.Status.Conditions = []napkinv1.NapkinVisualCondition{
+		{
+			Type:               "Ready",
+			Status:             "False",
+			LastTransitionTime: now,
+			Reason:             "Cancelled",
+			Message:            "Visual generation was cancelled",
+		},
+	}
 	r.Status().Update(ctx, visual)
+
 	return ctrl.Result{}, nil
 }
 
-// getAPIKey reads the Napkin API key from a referenced Kubernetes Secret
+// cancelGeneration cancels any Napkin requests that are still in flight.
+// Batch items are checked individually, since a batch still in Pending may
+// already have submitted some of them.
+func (r *NapkinVisualReconciler) cancelGeneration(ctx context.Context, visual *napkinv1.NapkinVisual) error {
+	var requestIDs []string
+	if visual.Status.NapkinRequestId != "" && isActivePhase(visual.Status.Phase) {
+		requestIDs = append(requestIDs, visual.Status.NapkinRequestId)
+	}
+	for _, item := range visual.Status.BatchItems {
+		if item.NapkinRequestId != "" && (item.Phase == phaseSubmitted || item.Phase == phaseProcessing) {
+			requestIDs = append(requestIDs, item.NapkinRequestId)
+		}
+	}
+	if len(requestIDs) == 0 {
+		return nil
+	}
+
+	apiKey, err := r.getAPIKey(ctx, visual)
+	if err != nil {
+		return fmt.Errorf("failed to read API key: %w", err)
+	}
+
+	napkin := r.napkinClient(apiKey)
+	for _, id := range requestIDs {
+		if err := napkin.Cancel(ctx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isActivePhase reports whether a generation is still in flight on the Napkin side
+func isActivePhase(phase string) bool {
+	switch phase {
+	case phasePending, phaseSubmitted, phaseProcessing:
+		return true
+	default:
+		return false
+	}
+}
+
+// napkinClient returns the Napkin API client for an API key, reusing the
+// shared client of visuals with the same key
+func (r *NapkinVisualReconciler) napkinClient(apiKey string) *napkinclient.Client {
+	if r.NapkinClients == nil {
+		return napkinclient.NewClient(r.NapkinURL, apiKey)
+	}
+	return r.NapkinClients.Get(apiKey)
+}
+
+// getAPIKey resolves the Napkin API key. Sources are tried in order: the
+// referenced Kubernetes Secret, the per-CR key file, then the operator default.
 func (r *NapkinVisualReconciler) getAPIKey(ctx context.Context, visual *napkinv1.NapkinVisual) (string, error) {
-	secretName := visual.Spec.ApiKeySecretRef.Name
-	if secretName == "" {
-		secretName = "napkin-api-secret"
+	var attempts []string
+
+	apiKey, err := r.getAPIKeyFromSecret(ctx, visual)
+	if err == nil {
+		return apiKey, nil
 	}
-	secretKey := visual.Spec.ApiKeySecretRef.Key
-	if secretKey == "" {
-		secretKey = "NAPKIN_API_KEY"
+	attempts = append(attempts, err.Error())
+
+	if visual.Spec.ApiKeyFile != "" {
+		apiKey, err := r.readAPIKeyFile(visual.Spec.ApiKeyFile)
+		if err == nil {
+			return apiKey, nil
+		}
+		attempts = append(attempts, err.Error())
 	}
 
+	if r.DefaultAPIKey != "" {
+		return r.DefaultAPIKey, nil
+	}
+	attempts = append(attempts, "no operator default API key configured")
+
+	return "", fmt.Errorf("no Napkin API key available: %s", strings.Join(attempts, "; "))
+}
+
+// readAPIKeyFile reads an API key file, which must resolve to a path inside
+// APIKeyDir. Failures share one message so a visual cannot probe which paths
+// exist in the operator pod.
+func (r *NapkinVisualReconciler) readAPIKeyFile(name string) (string, error) {
+	if r.APIKeyDir == "" {
+		return "", fmt.Errorf("key file %s not allowed: no API key directory configured", name)
+	}
+	unavailable := fmt.Errorf("key file %s is not available in the API key directory", name)
+
+	dir, err := filepath.EvalSymlinks(r.APIKeyDir)
+	if err != nil {
+		return "", unavailable
+	}
+	p := name
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(r.APIKeyDir, p)
+	}
+	p, err = filepath.EvalSymlinks(p)
+	if err != nil {
+		return "", unavailable
+	}
+	if rel, err := filepath.Rel(dir, p); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", unavailable
+	}
+
+	data, err := os.ReadFile(p)
+	if err != nil || strings.TrimSpace(string(data)) == "" {
+		return "", unavailable
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// getAPIKeyFromSecret reads the Napkin API key from a referenced Kubernetes Secret
+func (r *NapkinVisualReconciler) getAPIKeyFromSecret(ctx context.Context, visual *napkinv1.NapkinVisual) (string, error) {
+	secretName := visual.Spec.ApiKeySecretRef.Name
+	secretKey := visual.Spec.ApiKeySecretRef.Key
+
 	var secret corev1.Secret
 	if err := r.Get(ctx, types.NamespacedName{
 		Name:      secretName,
@@ -335,55 +1092,359 @@ func (r *NapkinVisualReconciler) getAPIKey(ctx context.Context, visual *napkinv1
 // setFailedStatus sets the visual status to Failed with an error message
 func (r *NapkinVisualReconciler) setFailedStatus(ctx context.Context, visual *napkinv1.NapkinVisual, message string) {
 	visual.Status.Phase = phaseFailed
-	visual.Status.LastError = message
+	visual.Status.LastError = truncateMessage(message, maxLastErrorLength)
 	visual.Status.RetryCount++
+	reason := "Failed"
+	if visual.Status.RetryCount >= maxRetries(visual) {
+		reason = "RetriesExhausted"
+	}
 	now := metav1.Now()
 	visual.Status.Conditions = []napkinv1.NapkinVisualCondition{
 		{
 			Type:               "Ready",
 			Status:             "False",
 			LastTransitionTime: now,
-			Reason:             "Failed",
+			Reason:             reason,
 			Message:            message,
 		},
 	}
 	r.Status().Update(ctx, visual)
 }
 
-// cleanupVisual deletes MinIO objects when the CR is deleted
+// resetForRetry clears the results of the previous attempt and moves the visual back to Pending
+func resetForRetry(visual *napkinv1.NapkinVisual, reason, message string) {
+	visual.Status.Phase = phasePending
+	visual.Status.NapkinRequestId = ""
+	visual.Status.RenderedContent = ""
+	visual.Status.SubmittedRequest = nil
+	visual.Status.RequestHash = ""
+	visual.Status.StorageAttempts = 0
+	visual.Status.CallbackAttempts = 0
+	visual.Status.BatchItems = nil
+	visual.Status.GeneratedFiles = nil
+	visual.Status.PrimaryUrl = ""
+	visual.Status.CompletionTime = nil
+	visual.Status.Conditions = []napkinv1.NapkinVisualCondition{
+		{
+			Type:               "Ready",
+			Status:             "False",
+			LastTransitionTime: metav1.Now(),
+			Reason:             reason,
+			Message:            message,
+		},
+	}
+}
+
+// maxRetries returns the number of failures after which a visual is no longer retried
+func maxRetries(visual *napkinv1.NapkinVisual) int {
+	if visual.Spec.MaxRetries != nil {
+		return *visual.Spec.MaxRetries
+	}
+	return defaultMaxRetries
+}
+
+// retryDelay returns how long to wait after a failure before retrying
+func retryDelay(visual *napkinv1.NapkinVisual) time.Duration {
+	if visual.Spec.RetryDelaySeconds > 0 {
+		return time.Duration(visual.Spec.RetryDelaySeconds) * time.Second
+	}
+	return defaultRetryDelay
+}
+
+// setPausedCondition adds or removes the Paused condition without touching the rest of the status
+func (r *NapkinVisualReconciler) setPausedCondition(ctx context.Context, visual *napkinv1.NapkinVisual, paused bool) error {
+	if paused {
+		setCondition(visual, napkinv1.NapkinVisualCondition{
+			Type:               "Paused",
+			Status:             "True",
+			LastTransitionTime: metav1.Now(),
+			Reason:             "PausedByAnnotation",
+			Message:            "Reconciliation is paused by the " + pausedAnnotation + " annotation",
+		})
+	} else {
+		removeCondition(visual, "Paused")
+	}
+	return r.Status().Update(ctx, visual)
+}
+
+// setCondition adds cond, replacing any existing condition of the same type
+func setCondition(visual *napkinv1.NapkinVisual, cond napkinv1.NapkinVisualCondition) {
+	removeCondition(visual, cond.Type)
+	visual.Status.Conditions = append(visual.Status.Conditions, cond)
+}
+
+// removeCondition drops the condition of the given type, if present
+func removeCondition(visual *napkinv1.NapkinVisual, condType string) {
+	conditions := visual.Status.Conditions[:0]
+	for _, cond := range visual.Status.Conditions {
+		if cond.Type != condType {
+			conditions = append(conditions, cond)
+		}
+	}
+	visual.Status.Conditions = conditions
+}
+
+// findCondition returns the condition of the given type, or nil if not present
+func findCondition(visual *napkinv1.NapkinVisual, condType string) *napkinv1.NapkinVisualCondition {
+	for i := range visual.Status.Conditions {
+		if visual.Status.Conditions[i].Type == condType {
+			return &visual.Status.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// cleanupVisual deletes stored objects when the CR is deleted
 func (r *NapkinVisualReconciler) cleanupVisual(ctx context.Context, visual *napkinv1.NapkinVisual) error {
 	ctx, span := r.tracer.Start(ctx, "cleanup_visual")
 	defer span.End()
 	logger := log.FromContext(ctx)
 
-	bucket := visual.Spec.Storage.Bucket
-	if bucket == "" {
-		bucket = "napkin-visuals"
+	// Stop the Napkin-side jobs if the visual is deleted mid-generation
+	if err := r.cancelGeneration(ctx, visual); err != nil {
+		logger.Error(err, "Failed to cancel visual generation during cleanup", "requestId", visual.Status.NapkinRequestId)
+		// Continue cleanup; the Napkin job will expire on its own
 	}
 
+	r.deleteStoredFiles(ctx, visual)
+	r.staged.clear(visual.UID)
+	return nil
+}
+
+// deleteStoredFiles removes the visual's generated files from storage.
+// Deduplicated files may be shared with other visuals and are kept.
+func (r *NapkinVisualReconciler) deleteStoredFiles(ctx context.Context, visual *napkinv1.NapkinVisual) {
+	if visual.Status.RequestHash != "" {
+		return
+	}
+	logger := log.FromContext(ctx)
+	bucket := bucketName(visual)
+
 	for _, file := range visual.Status.GeneratedFiles {
 		if file.MinioKey != "" {
-			if err := r.MinioClient.Delete(ctx, bucket, file.MinioKey); err != nil {
-				logger.Error(err, "Failed to delete MinIO object during cleanup", "key", file.MinioKey)
+			if err := r.Storage.Delete(ctx, bucket, file.MinioKey); err != nil {
+				logger.Error(err, "Failed to delete stored object during cleanup", "key", file.MinioKey)
 				// Continue cleanup even if individual deletes fail
 			}
 		}
 	}
+}
+
+// truncateMessage shortens a message to at most max runes, marking the cut with an ellipsis
+func truncateMessage(message string, max int) string {
+	runes := []rune(message)
+	if len(runes) <= max {
+		return message
+	}
+	return string(runes[:max-3]) + "..."
+}
+
+// bucketName returns the storage bucket for a visual. With BucketPerTenant the
+// configured bucket is used as a prefix for a dedicated per-tenant bucket.
+func bucketName(visual *napkinv1.NapkinVisual) string {
+	if visual.Spec.Storage.BucketPerTenant {
+		return minioclient.SanitizeBucketName(visual.Spec.Storage.Bucket + "-" + visual.Spec.TenantId)
+	}
+	return visual.Spec.Storage.Bucket
+}
+
+// specHash returns a hash of the spec fields that affect what Napkin generates.
+// content is the resolved, unrendered content.
+func specHash(visual *napkinv1.NapkinVisual, content string) string {
+	data, _ := json.Marshal(struct {
+		Content    string
+		Batch      []string
+		Variables  map[string]string
+		Context    string
+		Format     string
+		Style      napkinv1.NapkinStyleSpec
+		Language   string
+		Variations int
+		Width      int
+		Height     int
+		DPI        int
+	}{
+		Content:    content,
+		Batch:      visual.Spec.Batch,
+		Variables:  visual.Spec.Variables,
+		Context:    visual.Spec.Context,
+		Format:     visual.Spec.Format,
+		Style:      visual.Spec.Style,
+		Language:   visual.Spec.Language,
+		Variations: visual.Spec.Variations,
+		Width:      visual.Spec.Width,
+		Height:     visual.Spec.Height,
+		DPI:        visual.Spec.DPI,
+	})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// primaryURL returns the URL of the first stored file, or "" if none was stored
+func primaryURL(files []napkinv1.GeneratedFileStatus) string {
+	for _, file := range files {
+		if file.MinioUrl != "" {
+			return file.MinioUrl
+		}
+	}
+	return ""
+}
+
+// fileName returns the object name of a generated file. When both color
+// modes are requested, light and dark files share an index, so the color mode
+// is part of the name.
+func fileName(visual *napkinv1.NapkinVisual, file *napkinv1.GeneratedFileStatus) string {
+	if visual.Spec.Style.ColorMode == "both" && file.ColorMode != "" {
+		return fmt.Sprintf("%d-%s.%s", file.Index, file.ColorMode, file.Format)
+	}
+	return fmt.Sprintf("%d.%s", file.Index, file.Format)
+}
+
+// objectKey returns the object key for a generated file. Keys are
+// namespaced by tenant unless the tenant already has its own bucket, or laid
+// out by Storage.KeyTemplate when set. Deduplicated visuals are stored under
+// their request hash so identical requests share objects.
+func objectKey(visual *napkinv1.NapkinVisual, file *napkinv1.GeneratedFileStatus) (string, error) {
+	if visual.Status.RequestHash != "" {
+		return dedupDir(visual) + "/" + fileName(visual, file), nil
+	}
+
+	if tmpl := visual.Spec.Storage.KeyTemplate; tmpl != "" {
+		date := clock()
+		if visual.Status.StartTime != nil {
+			date = visual.Status.StartTime.Time
+		}
+		key, err := napkinv1.RenderKeyTemplate(tmpl, napkinv1.KeyTemplateData{
+			Tenant:     visual.Spec.TenantId,
+			Name:       visual.Name,
+			Namespace:  visual.Namespace,
+			Index:      file.Index,
+			BatchIndex: file.BatchIndex,
+			Format:     file.Format,
+			ColorMode:  file.ColorMode,
+			Date:       date,
+		})
+		if err != nil {
+			return "", err
+		}
+		return visual.Spec.Storage.Prefix + key, nil
+	}
+
+	dir := visual.Spec.Storage.Prefix
+	if !visual.Spec.Storage.BucketPerTenant {
+		dir += visual.Spec.TenantId + "/"
+	}
+	dir += visual.Name
+
+	if len(visual.Spec.Batch) > 0 {
+		return fmt.Sprintf("%s/%d/%s", dir, file.BatchIndex, fileName(visual, file)), nil
+	}
+	return dir + "/" + fileName(visual, file), nil
+}
+
+// dedupDir returns the directory holding the files generated for the visual's request hash
+func dedupDir(visual *napkinv1.NapkinVisual) string {
+	dir := visual.Spec.Storage.Prefix
+	if !visual.Spec.Storage.BucketPerTenant {
+		dir += visual.Spec.TenantId + "/"
+	}
+	return dir + "by-hash/" + visual.Status.RequestHash
+}
+
+// objectTags returns the MinIO object tags used for lifecycle rules and cost attribution
+func objectTags(visual *napkinv1.NapkinVisual, file *napkinv1.GeneratedFileStatus) map[string]string {
+	objTags := map[string]string{
+		"tenant": visual.Spec.TenantId,
+		"visual": visual.Name,
+		"format": file.Format,
+	}
+	if file.ColorMode != "" {
+		objTags["color_mode"] = file.ColorMode
+	}
+	if days := visual.Spec.Storage.ExpireAfterDays; days > 0 {
+		objTags[storage.ExpireAfterDaysTag] = strconv.Itoa(days)
+	}
+	return objTags
+}
+
+// formatValidators check that downloaded data matches the expected format
+var formatValidators = map[string]func(data []byte) bool{
+	"png": func(data []byte) bool {
+		return bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n"))
+	},
+	"svg": isSVG,
+	"ppt": func(data []byte) bool {
+		// PK zip header (pptx) or OLE compound file header (legacy ppt)
+		return bytes.HasPrefix(data, []byte("PK\x03\x04")) ||
+			bytes.HasPrefix(data, []byte("\xd0\xcf\x11\xe0\xa1\xb1\x1a\xe1"))
+	},
+}
+
+// svgHeadLimit bounds how far into a download the svg root element is looked for
+const svgHeadLimit = 4096
+
+// isSVG reports whether data has an <svg> root element, skipping a byte order
+// mark, the XML declaration, processing instructions, comments and a DOCTYPE
+func isSVG(data []byte) bool {
+	head := bytes.TrimPrefix(data[:min(len(data), svgHeadLimit)], []byte("\xef\xbb\xbf"))
+	for {
+		head = bytes.TrimLeft(head, " \t\r\n")
+		var end string
+		switch {
+		case bytes.HasPrefix(head, []byte("<svg")):
+			rest := head[len("<svg"):]
+			return len(rest) == 0 || bytes.IndexByte([]byte(" \t\r\n>/:"), rest[0]) >= 0
+		case bytes.HasPrefix(head, []byte("<?")):
+			end = "?>"
+		case bytes.HasPrefix(head, []byte("<!--")):
+			end = "-->"
+		case bytes.HasPrefix(head, []byte("<!DOCTYPE")):
+			end = ">"
+			// An internal subset contains markup of its own
+			if open := bytes.IndexByte(head, '['); open >= 0 && open < bytes.IndexByte(head, '>') {
+				end = "]>"
+			}
+		default:
+			return false
+		}
+		i := bytes.Index(head, []byte(end))
+		if i < 0 {
+			return false
+		}
+		head = head[i+len(end):]
+	}
+}
 
+// validateFileContent rejects empty downloads and data whose magic bytes
+// don't match the expected format
+func validateFileContent(format string, data []byte) error {
+	if len(data) == 0 {
+		return fmt.Errorf("downloaded file is empty")
+	}
+	if validate, ok := formatValidators[format]; ok && !validate(data) {
+		return fmt.Errorf("downloaded data does not look like a %s file", format)
+	}
 	return nil
 }
 
-// getContentType returns the MIME type for a file format
-func getContentType(format string) string {
+// getContentType returns the MIME type for a file format, sniffing the file
+// content when the format is not recognized
+func getContentType(format string, data []byte) string {
 	switch format {
 	case "svg":
 		return "image/svg+xml"
 	case "png":
 		return "image/png"
+	case "jpg", "jpeg":
+		return "image/jpeg"
+	case "webp":
+		return "image/webp"
+	case "pdf":
+		return "application/pdf"
 	case "ppt":
 		return "application/vnd.ms-powerpoint"
 	default:
-		return "application/octet-stream"
+		return http.DetectContentType(data)
 	}
 }
 
@@ -391,7 +1452,23 @@ func getContentType(format string) string {
 func (r *NapkinVisualReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	r.tracer = otel.Tracer("napkinvisual-controller")
 
+	if err := metrics.Registry.Register(newPhaseCollector(mgr.GetClient())); err != nil {
+		return err
+	}
+
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &napkinv1.NapkinVisual{}, contentFromIndex, indexContentFrom); err != nil {
+		return err
+	}
+
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&napkinv1.NapkinVisual{}).
+		WatchesMetadata(&corev1.ConfigMap{}, handler.EnqueueRequestsFromMapFunc(r.visualsForConfigMap)).
+		WithOptions(r.controllerOptions()).
 		Complete(r)
 }
+
+// controllerOptions returns the controller options, reconciling one visual at
+// a time unless MaxConcurrentReconciles is set
+func (r *NapkinVisualReconciler) controllerOptions() controller.Options {
+	return controller.Options{MaxConcurrentReconciles: max(r.MaxConcurrentReconciles, 1)}
+}
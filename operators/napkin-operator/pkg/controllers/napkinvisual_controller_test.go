@@ -0,0 +1,1290 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/util/jsonpath"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/yaml"
+
+	napkinv1 "github.com/Tributary-ai-services/napkin-operator/api/v1"
+	minioclient "github.com/Tributary-ai-services/napkin-operator/pkg/minio"
+	napkinclient "github.com/Tributary-ai-services/napkin-operator/pkg/napkin"
+	"github.com/Tributary-ai-services/napkin-operator/pkg/storage"
+)
+
+func TestObjectTagsSelectExpirationRule(t *testing.T) {
+	visual := &napkinv1.NapkinVisual{
+		ObjectMeta: metav1.ObjectMeta{Name: "diagram"},
+		Spec:       napkinv1.NapkinVisualSpec{TenantId: "acme"},
+	}
+	file := &napkinv1.GeneratedFileStatus{Format: "svg"}
+
+	if _, ok := objectTags(visual, file)["expire-after-days"]; ok {
+		t.Error("expected no expiry tag without expireAfterDays")
+	}
+
+	visual.Spec.Storage.ExpireAfterDays = 30
+	if got := objectTags(visual, file)["expire-after-days"]; got != "30" {
+		t.Errorf("expected expire-after-days=30, got %q", got)
+	}
+}
+
+// downloadingVisual returns a visual whose generated files are ready for download
+func downloadingVisual(napkin *fakeNapkin, name string, files int) *napkinv1.NapkinVisual {
+	visual := withPhase(newTestVisual(name), phaseDownloading)
+	for i := 0; i < files; i++ {
+		info := napkin.addFile(i, "svg", "light", svgData)
+		visual.Status.GeneratedFiles = append(visual.Status.GeneratedFiles, napkinv1.GeneratedFileStatus{
+			Index:     i,
+			Format:    "svg",
+			ColorMode: "light",
+			NapkinUrl: info.URL,
+		})
+	}
+	return visual
+}
+
+func TestUploadedObjectsCarryVisualTags(t *testing.T) {
+	r, napkin, store := newTestReconciler(t)
+	visual := downloadingVisual(napkin, "diagram", 1)
+	visual.Spec.TenantId = "acme"
+	visual.Status.GeneratedFiles[0].ColorMode = "dark"
+	if err := r.Create(context.Background(), visual); err != nil {
+		t.Fatal(err)
+	}
+
+	reconcileVisual(t, r, "diagram")
+
+	keys := store.keys()
+	if len(keys) != 1 {
+		t.Fatalf("expected one stored file, got %v", keys)
+	}
+	want := map[string]string{"tenant": "acme", "visual": "diagram", "format": "svg", "color_mode": "dark"}
+	got := store.tags[keys[0]]
+	if len(got) != len(want) {
+		t.Errorf("expected tags %v, got %v", want, got)
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("tag %s: got %q, want %q", key, got[key], value)
+		}
+	}
+}
+
+func TestTransferFilesBoundsConcurrency(t *testing.T) {
+	r, napkin, store := newTestReconciler(t)
+	napkin.downloadDelay = 20 * time.Millisecond
+	r.DownloadConcurrency = 2
+	if err := r.Create(context.Background(), downloadingVisual(napkin, "diagram", 6)); err != nil {
+		t.Fatal(err)
+	}
+
+	reconcileVisual(t, r, "diagram")
+
+	visual := getVisual(t, r, "diagram")
+	if visual.Status.Phase != phaseCompleted {
+		t.Fatalf("expected Completed, got %s (%s)", visual.Status.Phase, visual.Status.LastError)
+	}
+	if got := len(store.keys()); got != 6 {
+		t.Errorf("expected 6 stored files, got %d", got)
+	}
+	if napkin.maxInflight > 2 {
+		t.Errorf("expected at most 2 concurrent downloads, got %d", napkin.maxInflight)
+	}
+}
+
+func TestTransferFilesReportsEveryFailure(t *testing.T) {
+	r, napkin, store := newTestReconciler(t)
+	visual := downloadingVisual(napkin, "diagram", 3)
+	visual.Status.GeneratedFiles[0].NapkinUrl = napkin.URL + "/files/missing-0"
+	visual.Status.GeneratedFiles[2].NapkinUrl = napkin.URL + "/files/missing-2"
+	if err := r.Create(context.Background(), visual); err != nil {
+		t.Fatal(err)
+	}
+
+	reconcileVisual(t, r, "diagram")
+
+	visual = getVisual(t, r, "diagram")
+	if visual.Status.Phase != phaseFailed {
+		t.Fatalf("expected Failed, got %s", visual.Status.Phase)
+	}
+	message := findCondition(visual, "Ready").Message
+	for _, want := range []string{"file 0", "file 2"} {
+		if !strings.Contains(message, want) {
+			t.Errorf("expected %q in %q", want, message)
+		}
+	}
+	// The healthy file is still transferred
+	if got := len(store.keys()); got != 1 {
+		t.Errorf("expected the remaining file to be stored, got %v", store.keys())
+	}
+}
+
+func TestCancelAnnotationCancelsInflightGeneration(t *testing.T) {
+	visual := withPhase(newTestVisual("diagram"), phaseProcessing)
+	visual.Status.NapkinRequestId = "req-7"
+	visual.Annotations = map[string]string{cancelAnnotation: "true"}
+	r, napkin, _ := newTestReconciler(t, visual)
+
+	reconcileVisual(t, r, "diagram")
+
+	visual = getVisual(t, r, "diagram")
+	if visual.Status.Phase != phaseCancelled || readyReason(visual) != "Cancelled" {
+		t.Fatalf("expected Cancelled, got %s/%s", visual.Status.Phase, readyReason(visual))
+	}
+	if got := napkin.cancelled(); len(got) != 1 || got[0] != "req-7" {
+		t.Errorf("expected the Napkin request to be cancelled, got %v", got)
+	}
+}
+
+func TestCancelAnnotationIgnoredOnceCompleted(t *testing.T) {
+	visual := withPhase(newTestVisual("diagram"), phaseCompleted)
+	visual.Annotations = map[string]string{cancelAnnotation: "true"}
+	r, napkin, _ := newTestReconciler(t, visual)
+
+	reconcileVisual(t, r, "diagram")
+
+	if phase := getVisual(t, r, "diagram").Status.Phase; phase != phaseCompleted {
+		t.Errorf("expected Completed to be kept, got %s", phase)
+	}
+	if got := napkin.cancelled(); len(got) != 0 {
+		t.Errorf("expected no cancellation, got %v", got)
+	}
+}
+
+func TestControllerOptionsMaxConcurrentReconciles(t *testing.T) {
+	for _, tt := range []struct{ configured, want int }{{0, 1}, {-2, 1}, {1, 1}, {8, 8}} {
+		r := &NapkinVisualReconciler{MaxConcurrentReconciles: tt.configured}
+		if got := r.controllerOptions().MaxConcurrentReconciles; got != tt.want {
+			t.Errorf("MaxConcurrentReconciles %d: got %d, want %d", tt.configured, got, tt.want)
+		}
+	}
+}
+
+func TestConcurrentReconcilesSubmitEachVisualOnce(t *testing.T) {
+	const n = 8
+	var objs []client.Object
+	for i := 0; i < n; i++ {
+		objs = append(objs, withPhase(newTestVisual(fmt.Sprintf("diagram-%d", i)), phasePending))
+	}
+	r, napkin, _ := newTestReconciler(t, objs...)
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reconcileVisual(t, r, fmt.Sprintf("diagram-%d", i))
+		}()
+	}
+	wg.Wait()
+
+	if got := len(napkin.submitted()); got != n {
+		t.Errorf("expected %d submissions, got %d", n, got)
+	}
+	for i := 0; i < n; i++ {
+		if visual := getVisual(t, r, fmt.Sprintf("diagram-%d", i)); visual.Status.Phase != phaseSubmitted {
+			t.Errorf("diagram-%d: expected Submitted, got %s", i, visual.Status.Phase)
+		}
+	}
+}
+
+func writeKeyFile(t *testing.T, path, key string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(key+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetAPIKeySources(t *testing.T) {
+	keyDir := t.TempDir()
+	writeKeyFile(t, filepath.Join(keyDir, "napkin"), "file-key")
+	outside := t.TempDir()
+	writeKeyFile(t, filepath.Join(outside, "napkin"), "outside-key")
+	if err := os.Symlink(filepath.Join(outside, "napkin"), filepath.Join(keyDir, "escape")); err != nil {
+		t.Fatal(err)
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: napkinv1.DefaultApiKeySecretName, Namespace: testNamespace},
+		Data:       map[string][]byte{napkinv1.DefaultApiKeySecretKey: []byte("secret-key")},
+	}
+
+	tests := []struct {
+		name       string
+		secret     bool
+		keyFile    string
+		defaultKey string
+		want       string
+		wantErr    string
+	}{
+		{name: "secret wins over file and default", secret: true, keyFile: "napkin", defaultKey: "default-key", want: "secret-key"},
+		{name: "file wins over default", keyFile: "napkin", defaultKey: "default-key", want: "file-key"},
+		{name: "absolute path inside the directory", keyFile: filepath.Join(keyDir, "napkin"), want: "file-key"},
+		{name: "default", defaultKey: "default-key", want: "default-key"},
+		{name: "unreadable file falls back to default", keyFile: "missing", defaultKey: "default-key", want: "default-key"},
+		{name: "path outside the directory", keyFile: filepath.Join(outside, "napkin"), wantErr: "is not available"},
+		{name: "relative escape", keyFile: "../" + filepath.Base(outside) + "/napkin", wantErr: "is not available"},
+		{name: "symlink escape", keyFile: "escape", wantErr: "is not available"},
+		{name: "missing file", keyFile: "missing", wantErr: "is not available"},
+		{name: "no source", wantErr: "no operator default API key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var objs []client.Object
+			if tt.secret {
+				objs = append(objs, secret)
+			}
+			r, _, _ := newTestReconciler(t, objs...)
+			r.DefaultAPIKey = tt.defaultKey
+			r.APIKeyDir = keyDir
+			visual := newTestVisual("diagram")
+			visual.Spec.ApiKeyFile = tt.keyFile
+
+			got, err := r.getAPIKey(context.Background(), visual)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got key %q, err %v", tt.wantErr, got, err)
+				}
+				if strings.Contains(err.Error(), "no such file") {
+					t.Errorf("error reveals whether the path exists: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("getAPIKey: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got key %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetAPIKeyFileRequiresDirectory(t *testing.T) {
+	keyDir := t.TempDir()
+	writeKeyFile(t, filepath.Join(keyDir, "napkin"), "file-key")
+	r, _, _ := newTestReconciler(t)
+	r.DefaultAPIKey = ""
+	visual := newTestVisual("diagram")
+	visual.Spec.ApiKeyFile = filepath.Join(keyDir, "napkin")
+
+	if _, err := r.getAPIKey(context.Background(), visual); err == nil || !strings.Contains(err.Error(), "no API key directory configured") {
+		t.Fatalf("expected key files to be disabled without --api-key-dir, got %v", err)
+	}
+}
+
+// crdPrinterColumns reads the printer columns from the NapkinVisual CRD manifest
+func crdPrinterColumns(t *testing.T) map[string]string {
+	t.Helper()
+	data, err := os.ReadFile("../../deployments/kubernetes/crds/napkinvisual_crd.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var crd struct {
+		Spec struct {
+			Versions []struct {
+				AdditionalPrinterColumns []struct {
+					Name     string `json:"name"`
+					JSONPath string `json:"jsonPath"`
+					Priority int    `json:"priority"`
+				} `json:"additionalPrinterColumns"`
+			} `json:"versions"`
+		} `json:"spec"`
+	}
+	if err := yaml.Unmarshal(data, &crd); err != nil {
+		t.Fatal(err)
+	}
+	columns := make(map[string]string)
+	for _, column := range crd.Spec.Versions[0].AdditionalPrinterColumns {
+		if column.Priority != 0 {
+			continue
+		}
+		columns[column.Name] = column.JSONPath
+	}
+	return columns
+}
+
+func TestFailedVisualPopulatesPrinterColumns(t *testing.T) {
+	r, napkin, _ := newTestReconciler(t, withPhase(newTestVisual("diagram"), phasePending))
+	napkin.submitStatus = func(int) int { return http.StatusBadRequest }
+
+	reconcileVisual(t, r, "diagram")
+
+	visual := getVisual(t, r, "diagram")
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(visual)
+	if err != nil {
+		t.Fatal(err)
+	}
+	columns := crdPrinterColumns(t)
+	for name, want := range map[string]string{"Phase": phaseFailed, "Reason": "Failed", "Retries": "1"} {
+		if got := evalColumn(t, columns, name, obj); got != want {
+			t.Errorf("column %s: got %q, want %q", name, got, want)
+		}
+	}
+	errColumn := evalColumn(t, columns, "Error", obj)
+	if !strings.HasPrefix(errColumn, "Failed to submit") || len(errColumn) > maxLastErrorLength {
+		t.Errorf("column Error: expected a short submit error, got %q", errColumn)
+	}
+}
+
+func evalColumn(t *testing.T, columns map[string]string, name string, obj map[string]interface{}) string {
+	t.Helper()
+	path, ok := columns[name]
+	if !ok {
+		t.Fatalf("no default printer column %s", name)
+	}
+	jp := jsonpath.New(name)
+	if err := jp.Parse("{" + path + "}"); err != nil {
+		t.Fatalf("column %s: %v", name, err)
+	}
+	var out strings.Builder
+	if err := jp.Execute(&out, obj); err != nil {
+		t.Fatalf("column %s: %v", name, err)
+	}
+	return out.String()
+}
+
+// backdateReady moves the Ready condition's transition time into the past so
+// the retry delay has elapsed
+func backdateReady(t *testing.T, r *NapkinVisualReconciler, name string, by time.Duration) {
+	t.Helper()
+	visual := getVisual(t, r, name)
+	cond := findCondition(visual, "Ready")
+	cond.LastTransitionTime = metav1.NewTime(cond.LastTransitionTime.Add(-by))
+	if err := r.Status().Update(context.Background(), visual); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFailedVisualRetriesUntilMaxRetries(t *testing.T) {
+	visual := withPhase(newTestVisual("diagram"), phasePending)
+	maxRetries := 2
+	visual.Spec.MaxRetries = &maxRetries
+	visual.Spec.RetryDelaySeconds = 60
+	r, napkin, _ := newTestReconciler(t, visual)
+	napkin.submitStatus = func(int) int { return http.StatusBadRequest }
+
+	reconcileVisual(t, r, "diagram")
+	visual = getVisual(t, r, "diagram")
+	if visual.Status.Phase != phaseFailed || visual.Status.RetryCount != 1 || readyReason(visual) != "Failed" {
+		t.Fatalf("expected a retryable failure, got %s, %d retries, reason %s", visual.Status.Phase, visual.Status.RetryCount, readyReason(visual))
+	}
+
+	// The retry waits for the configured delay
+	result := reconcileVisual(t, r, "diagram")
+	if result.RequeueAfter <= 0 || result.RequeueAfter > 60*time.Second {
+		t.Errorf("expected a requeue within the retry delay, got %v", result.RequeueAfter)
+	}
+	if visual := getVisual(t, r, "diagram"); visual.Status.Phase != phaseFailed {
+		t.Fatalf("expected the visual to stay Failed during the delay, got %s", visual.Status.Phase)
+	}
+
+	backdateReady(t, r, "diagram", time.Minute)
+	reconcileVisual(t, r, "diagram")
+	if visual := getVisual(t, r, "diagram"); visual.Status.Phase != phasePending || readyReason(visual) != "Retrying" {
+		t.Fatalf("expected a retry after the delay, got %s (%s)", visual.Status.Phase, readyReason(visual))
+	}
+
+	reconcileVisual(t, r, "diagram")
+	visual = getVisual(t, r, "diagram")
+	if visual.Status.Phase != phaseFailed || visual.Status.RetryCount != 2 || readyReason(visual) != "RetriesExhausted" {
+		t.Fatalf("expected retries to be exhausted, got %s, %d retries, reason %s", visual.Status.Phase, visual.Status.RetryCount, readyReason(visual))
+	}
+
+	// An exhausted visual is terminal
+	backdateReady(t, r, "diagram", time.Hour)
+	if result := reconcileVisual(t, r, "diagram"); result.Requeue || result.RequeueAfter != 0 {
+		t.Errorf("expected no requeue once retries are exhausted, got %+v", result)
+	}
+	if visual := getVisual(t, r, "diagram"); visual.Status.Phase != phaseFailed {
+		t.Errorf("expected the visual to stay Failed, got %s", visual.Status.Phase)
+	}
+	if got := len(napkin.submitted()); got != 2 {
+		t.Errorf("expected one submission per attempt, got %d", got)
+	}
+}
+
+func setAnnotation(t *testing.T, r *NapkinVisualReconciler, name, key, value string) {
+	t.Helper()
+	visual := getVisual(t, r, name)
+	if visual.Annotations == nil {
+		visual.Annotations = map[string]string{}
+	}
+	visual.Annotations[key] = value
+	if err := r.Update(context.Background(), visual); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRetryAnnotationRetriesOncePerValue(t *testing.T) {
+	visual := withPhase(newTestVisual("diagram"), phasePending)
+	maxRetries := 1
+	visual.Spec.MaxRetries = &maxRetries
+	r, napkin, _ := newTestReconciler(t, visual)
+	napkin.submitStatus = func(int) int { return http.StatusBadRequest }
+
+	reconcileVisual(t, r, "diagram")
+	if visual := getVisual(t, r, "diagram"); readyReason(visual) != "RetriesExhausted" {
+		t.Fatalf("expected retries to be exhausted, got %s", readyReason(visual))
+	}
+
+	for i, token := range []string{"1", "2"} {
+		setAnnotation(t, r, "diagram", retryAnnotation, token)
+
+		reconcileVisual(t, r, "diagram")
+		visual := getVisual(t, r, "diagram")
+		if visual.Status.Phase != phasePending || visual.Status.RetryCount != 0 || visual.Status.LastError != "" {
+			t.Fatalf("token %s: expected a reset to Pending, got %s, %d retries, error %q", token, visual.Status.Phase, visual.Status.RetryCount, visual.Status.LastError)
+		}
+		if visual.Status.LastRetryToken != token {
+			t.Errorf("token %s: expected the token to be recorded, got %q", token, visual.Status.LastRetryToken)
+		}
+
+		// The retry fails again; the processed token doesn't trigger another one
+		reconcileVisual(t, r, "diagram")
+		reconcileVisual(t, r, "diagram")
+		if visual := getVisual(t, r, "diagram"); visual.Status.Phase != phaseFailed {
+			t.Fatalf("token %s: expected the retry to fail, got %s", token, visual.Status.Phase)
+		}
+		if got := len(napkin.submitted()); got != i+2 {
+			t.Errorf("token %s: expected %d submissions, got %d", token, i+2, got)
+		}
+	}
+}
+
+func TestBucketPerTenantStoresAndCleansUpInTenantBucket(t *testing.T) {
+	r, napkin, store := newTestReconciler(t)
+	visual := downloadingVisual(napkin, "diagram", 1)
+	visual.Spec.TenantId = "Acme Corp"
+	visual.Spec.Storage.BucketPerTenant = true
+	if err := r.Create(context.Background(), visual); err != nil {
+		t.Fatal(err)
+	}
+	bucket := minioclient.SanitizeBucketName(napkinv1.DefaultBucket + "-Acme Corp")
+	if !strings.HasPrefix(bucket, napkinv1.DefaultBucket+"-acme-corp-") {
+		t.Fatalf("unexpected tenant bucket %q", bucket)
+	}
+
+	reconcileVisual(t, r, "diagram")
+
+	keys := store.keys()
+	if len(keys) != 1 || !strings.HasPrefix(keys[0], bucket+"/") {
+		t.Fatalf("expected the file in bucket %s, got %v", bucket, keys)
+	}
+
+	if err := r.Delete(context.Background(), getVisual(t, r, "diagram")); err != nil {
+		t.Fatal(err)
+	}
+	reconcileVisual(t, r, "diagram")
+	if keys := store.keys(); len(keys) != 0 {
+		t.Errorf("expected cleanup to delete from the tenant bucket, got %v", keys)
+	}
+}
+
+func TestValidateStyleWarnsAboutUnknownStyles(t *testing.T) {
+	tests := []struct {
+		name            string
+		styleID         string
+		stylesStatus    int
+		wasUnavailable  bool
+		wantWarning     bool
+		wantUnavailable bool
+	}{
+		{name: "known style", styleID: "sketch"},
+		{name: "unknown style", styleID: "bogus", wantWarning: true},
+		{name: "styles unavailable", styleID: "bogus", stylesStatus: http.StatusServiceUnavailable, wantUnavailable: true},
+		{name: "styles available again", styleID: "sketch", wasUnavailable: true},
+		{name: "no style", styleID: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			visual := withPhase(newTestVisual("diagram"), phasePending)
+			visual.Spec.Style.StyleId = tt.styleID
+			if tt.wasUnavailable {
+				visual.Status.Conditions = []napkinv1.NapkinVisualCondition{{Type: "StylesUnavailable", Status: "True", Reason: "StyleListFailed"}}
+			}
+			r, napkin, _ := newTestReconciler(t, visual)
+			r.StyleCache = napkinclient.NewStyleCache(time.Minute)
+			napkin.styles = []napkinclient.Style{{ID: "sketch"}}
+			napkin.stylesStatus = tt.stylesStatus
+
+			reconcileVisual(t, r, "diagram")
+
+			warned := false
+			for _, event := range drainEvents(r) {
+				if strings.Contains(event, "UnknownStyle") {
+					warned = true
+				}
+			}
+			if warned != tt.wantWarning {
+				t.Errorf("expected UnknownStyle warning %v, got %v", tt.wantWarning, warned)
+			}
+			visual = getVisual(t, r, "diagram")
+			if visual.Status.Phase != phaseSubmitted {
+				t.Errorf("expected style validation not to block submission, got %s", visual.Status.Phase)
+			}
+			if unavailable := findCondition(visual, "StylesUnavailable") != nil; unavailable != tt.wantUnavailable {
+				t.Errorf("expected StylesUnavailable condition %v, got %+v", tt.wantUnavailable, visual.Status.Conditions)
+			}
+		})
+	}
+}
+
+func TestValidateFileContent(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		data   string
+		valid  bool
+	}{
+		{name: "png", format: "png", data: "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR", valid: true},
+		{name: "png as html", format: "png", data: "<html>error</html>"},
+		{name: "truncated png signature", format: "png", data: "\x89PNG"},
+		{name: "svg", format: "svg", data: `<svg xmlns="http://www.w3.org/2000/svg"></svg>`, valid: true},
+		{name: "svg with BOM and whitespace", format: "svg", data: "\xef\xbb\xbf\n  <svg/>", valid: true},
+		{name: "svg with declaration", format: "svg", data: `<?xml version="1.0" encoding="UTF-8"?>` + "\n<svg>", valid: true},
+		{name: "svg with comments and doctype", format: "svg", valid: true,
+			data: `<?xml version="1.0"?><!-- Generator: Napkin --><!DOCTYPE svg PUBLIC "-//W3C//DTD SVG 1.1//EN" "http://www.w3.org/Graphics/SVG/1.1/DTD/svg11.dtd"><svg width="10">`},
+		{name: "svg with doctype subset", format: "svg", data: `<!DOCTYPE svg [<!ENTITY ns "http://www.w3.org/2000/svg">]><svg xmlns="&ns;">`, valid: true},
+		{name: "prefixed svg", format: "svg", data: `<svg:svg xmlns:svg="http://www.w3.org/2000/svg"/>`, valid: true},
+		{name: "xml that isn't svg", format: "svg", data: `<?xml version="1.0"?><Error><Code>AccessDenied</Code></Error>`},
+		{name: "html with a comment", format: "svg", data: `<!-- svg --><html><body>error</body></html>`},
+		{name: "unterminated comment", format: "svg", data: `<!-- <svg>`},
+		{name: "svg-like element", format: "svg", data: `<svgfoo/>`},
+		{name: "json", format: "svg", data: `{"error":"not found"}`},
+		{name: "pptx", format: "ppt", data: "PK\x03\x04\x14\x00", valid: true},
+		{name: "legacy ppt", format: "ppt", data: "\xd0\xcf\x11\xe0\xa1\xb1\x1a\xe1\x00", valid: true},
+		{name: "ppt as text", format: "ppt", data: "Internal Server Error"},
+		{name: "empty png", format: "png"},
+		{name: "empty svg", format: "svg"},
+		{name: "empty ppt", format: "ppt"},
+		{name: "unknown format", format: "pdf", data: "%PDF-1.7", valid: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateFileContent(tt.format, []byte(tt.data))
+			if (err == nil) != tt.valid {
+				t.Errorf("expected valid %v, got %v", tt.valid, err)
+			}
+		})
+	}
+}
+
+func TestCorruptDownloadFailsVisual(t *testing.T) {
+	r, napkin, store := newTestReconciler(t)
+	visual := downloadingVisual(napkin, "diagram", 1)
+	napkin.addFile(0, "svg", "light", []byte(`<?xml version="1.0"?><Error>AccessDenied</Error>`))
+	if err := r.Create(context.Background(), visual); err != nil {
+		t.Fatal(err)
+	}
+
+	reconcileVisual(t, r, "diagram")
+
+	visual = getVisual(t, r, "diagram")
+	if visual.Status.Phase != phaseFailed || !strings.Contains(visual.Status.LastError, "does not look like a svg file") {
+		t.Errorf("expected a format failure, got %s (%s)", visual.Status.Phase, visual.Status.LastError)
+	}
+	if keys := store.keys(); len(keys) != 0 {
+		t.Errorf("expected nothing to be uploaded, got %v", keys)
+	}
+}
+
+func TestPollIntervalRespectsEstimateBounds(t *testing.T) {
+	tests := []struct {
+		name             string
+		maxPollInterval  time.Duration
+		estimatedSeconds int
+		want             time.Duration
+	}{
+		{name: "no estimate", estimatedSeconds: 0, want: defaultPollInterval},
+		{name: "negative estimate", estimatedSeconds: -5, want: defaultPollInterval},
+		{name: "below the minimum", estimatedSeconds: 1, want: minPollInterval},
+		{name: "within bounds", estimatedSeconds: 20, want: 20 * time.Second},
+		{name: "above the default maximum", estimatedSeconds: 600, want: defaultMaxPollInterval},
+		{name: "above the configured maximum", maxPollInterval: 30 * time.Second, estimatedSeconds: 120, want: 30 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &NapkinVisualReconciler{MaxPollInterval: tt.maxPollInterval}
+			if got := r.pollInterval(tt.estimatedSeconds); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPollingRequeuesAfterEstimate(t *testing.T) {
+	visual := withPhase(newTestVisual("diagram"), phaseSubmitted)
+	visual.Status.NapkinRequestId = "req-0"
+	r, napkin, _ := newTestReconciler(t, visual)
+	r.MaxPollInterval = 30 * time.Second
+
+	for _, tt := range []struct {
+		estimatedSeconds int
+		want             time.Duration
+	}{{10, 10 * time.Second}, {120, 30 * time.Second}} {
+		napkin.mu.Lock()
+		napkin.status["req-0"] = napkinclient.StatusResponse{ID: "req-0", Status: "processing", EstimatedSeconds: tt.estimatedSeconds}
+		napkin.mu.Unlock()
+
+		if got := reconcileVisual(t, r, "diagram").RequeueAfter; got != tt.want {
+			t.Errorf("estimate %ds: expected requeue after %v, got %v", tt.estimatedSeconds, tt.want, got)
+		}
+	}
+}
+
+func TestControllerDefaultsAreNotPersisted(t *testing.T) {
+	undefaulted := &napkinv1.NapkinVisual{
+		ObjectMeta: metav1.ObjectMeta{Name: "diagram", Namespace: testNamespace},
+		Spec:       napkinv1.NapkinVisualSpec{Content: "Client calls the API"},
+	}
+	r, napkin, _ := newTestReconciler(t, undefaulted)
+
+	for i := 0; i < 3; i++ {
+		reconcileVisual(t, r, "diagram")
+	}
+
+	visual := getVisual(t, r, "diagram")
+	if !controllerutil.ContainsFinalizer(visual, finalizerName) {
+		t.Error("expected the finalizer to be added")
+	}
+	if visual.Spec.Format != "" || visual.Spec.TenantId != "" || visual.Spec.Storage.Bucket != "" {
+		t.Errorf("expected the stored spec to be left undefaulted, got %+v", visual.Spec)
+	}
+	if visual.Status.Phase != phaseSubmitted {
+		t.Fatalf("expected Submitted, got %s", visual.Status.Phase)
+	}
+	if submits := napkin.submitted(); len(submits) != 1 || submits[0].Format != napkinv1.DefaultFormat {
+		t.Errorf("expected the defaulted format to be submitted, got %+v", submits)
+	}
+}
+
+func TestContentEditRegeneratesOnlyWhenEnabled(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		t.Run(fmt.Sprintf("regenerateOnChange=%v", enabled), func(t *testing.T) {
+			visual := withPhase(newTestVisual("diagram"), phasePending)
+			visual.Spec.RegenerateOnChange = enabled
+			r, napkin, store := newTestReconciler(t, visual)
+			napkin.complete("req-0", napkin.addFile(0, "svg", "light", svgData))
+			reconcileUntil(t, r, "diagram", phaseCompleted)
+			if len(store.keys()) != 1 {
+				t.Fatalf("expected one stored file, got %v", store.keys())
+			}
+
+			// Reconciling an unchanged visual doesn't regenerate it
+			reconcileVisual(t, r, "diagram")
+			if visual := getVisual(t, r, "diagram"); visual.Status.Phase != phaseCompleted {
+				t.Fatalf("expected an unchanged visual to stay Completed, got %s", visual.Status.Phase)
+			}
+
+			visual = getVisual(t, r, "diagram")
+			visual.Spec.Content = "Client calls the API through a gateway"
+			if err := r.Update(context.Background(), visual); err != nil {
+				t.Fatal(err)
+			}
+			reconcileVisual(t, r, "diagram")
+
+			visual = getVisual(t, r, "diagram")
+			if !enabled {
+				if visual.Status.Phase != phaseCompleted || len(store.keys()) != 1 {
+					t.Errorf("expected no regeneration, got %s with files %v", visual.Status.Phase, store.keys())
+				}
+				return
+			}
+			if visual.Status.Phase != phasePending || readyReason(visual) != "SpecChanged" {
+				t.Fatalf("expected regeneration, got %s (%s)", visual.Status.Phase, readyReason(visual))
+			}
+			if keys := store.keys(); len(keys) != 0 {
+				t.Errorf("expected the previous files to be deleted, got %v", keys)
+			}
+			reconcileVisual(t, r, "diagram")
+			if submits := napkin.submitted(); len(submits) != 2 || submits[1].Content != "Client calls the API through a gateway" {
+				t.Errorf("expected the edited content to be submitted, got %+v", submits)
+			}
+		})
+	}
+}
+
+func TestReconcilesShareNapkinClient(t *testing.T) {
+	r, _, _ := newTestReconciler(t,
+		withPhase(newTestVisual("first"), phasePending),
+		withPhase(newTestVisual("second"), phasePending))
+	r.NapkinClients = napkinclient.NewClientCache(r.NapkinURL)
+	shared := r.napkinClient(r.DefaultAPIKey)
+
+	reconcileVisual(t, r, "first")
+	reconcileVisual(t, r, "second")
+
+	if r.napkinClient(r.DefaultAPIKey) != shared {
+		t.Error("expected visuals with the same API key to share one client")
+	}
+	if r.napkinClient("other-key") == shared {
+		t.Error("expected a different API key to get its own client")
+	}
+}
+
+func TestPrimaryURL(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []napkinv1.GeneratedFileStatus
+		want  string
+	}{
+		{name: "no files"},
+		{name: "nothing stored", files: []napkinv1.GeneratedFileStatus{{Index: 0}, {Index: 1}}},
+		{name: "first file", files: []napkinv1.GeneratedFileStatus{{MinioUrl: "mem://a"}, {MinioUrl: "mem://b"}}, want: "mem://a"},
+		{name: "first stored file", files: []napkinv1.GeneratedFileStatus{{Index: 0}, {MinioUrl: "mem://b"}}, want: "mem://b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := primaryURL(tt.files); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompletedVisualHasPrimaryURL(t *testing.T) {
+	r, napkin, _ := newTestReconciler(t)
+	if err := r.Create(context.Background(), downloadingVisual(napkin, "diagram", 2)); err != nil {
+		t.Fatal(err)
+	}
+
+	reconcileVisual(t, r, "diagram")
+
+	visual := getVisual(t, r, "diagram")
+	if visual.Status.Phase != phaseCompleted {
+		t.Fatalf("expected Completed, got %s (%s)", visual.Status.Phase, visual.Status.LastError)
+	}
+	if len(visual.Status.GeneratedFiles) != 2 {
+		t.Fatalf("expected the full file list to be kept, got %+v", visual.Status.GeneratedFiles)
+	}
+	if want := visual.Status.GeneratedFiles[0].MinioUrl; want == "" || visual.Status.PrimaryUrl != want {
+		t.Errorf("expected PrimaryUrl %q, got %q", want, visual.Status.PrimaryUrl)
+	}
+}
+
+func TestPausedVisualIsNotUpdated(t *testing.T) {
+	visual := withPhase(newTestVisual("diagram"), phasePending)
+	visual.Annotations = map[string]string{pausedAnnotation: "true"}
+	r, napkin, _ := newTestReconciler(t, visual)
+
+	reconcileVisual(t, r, "diagram")
+	visual = getVisual(t, r, "diagram")
+	if cond := findCondition(visual, "Paused"); cond == nil || cond.Status != "True" {
+		t.Fatalf("expected a Paused condition, got %+v", visual.Status.Conditions)
+	}
+
+	resourceVersion := visual.ResourceVersion
+	for i := 0; i < 3; i++ {
+		if result := reconcileVisual(t, r, "diagram"); result.Requeue || result.RequeueAfter != 0 {
+			t.Errorf("expected no requeue while paused, got %+v", result)
+		}
+	}
+	visual = getVisual(t, r, "diagram")
+	if visual.ResourceVersion != resourceVersion {
+		t.Errorf("expected no updates while paused, resourceVersion %s -> %s", resourceVersion, visual.ResourceVersion)
+	}
+	if visual.Status.Phase != phasePending || len(napkin.submitted()) != 0 {
+		t.Errorf("expected nothing to be submitted while paused, got %s with %d submissions", visual.Status.Phase, len(napkin.submitted()))
+	}
+
+	delete(visual.Annotations, pausedAnnotation)
+	if err := r.Update(context.Background(), visual); err != nil {
+		t.Fatal(err)
+	}
+	reconcileVisual(t, r, "diagram")
+	if visual := getVisual(t, r, "diagram"); findCondition(visual, "Paused") != nil {
+		t.Errorf("expected the Paused condition to be removed, got %+v", visual.Status.Conditions)
+	}
+	reconcileVisual(t, r, "diagram")
+	if visual := getVisual(t, r, "diagram"); visual.Status.Phase != phaseSubmitted {
+		t.Errorf("expected reconciliation to resume, got %s", visual.Status.Phase)
+	}
+}
+
+func TestPausedVisualIsStillCleanedUp(t *testing.T) {
+	r, napkin, store := newTestReconciler(t)
+	if err := r.Create(context.Background(), downloadingVisual(napkin, "diagram", 1)); err != nil {
+		t.Fatal(err)
+	}
+	reconcileVisual(t, r, "diagram")
+	setAnnotation(t, r, "diagram", pausedAnnotation, "true")
+	reconcileVisual(t, r, "diagram")
+
+	if err := r.Delete(context.Background(), getVisual(t, r, "diagram")); err != nil {
+		t.Fatal(err)
+	}
+	reconcileVisual(t, r, "diagram")
+
+	if keys := store.keys(); len(keys) != 0 {
+		t.Errorf("expected a paused visual's files to be deleted, got %v", keys)
+	}
+	var visual napkinv1.NapkinVisual
+	if err := r.Get(context.Background(), client.ObjectKey{Namespace: testNamespace, Name: "diagram"}, &visual); err == nil {
+		t.Errorf("expected the finalizer to be removed, got %v", visual.Finalizers)
+	}
+}
+
+func TestSubmittedRequestSummaryIsRecorded(t *testing.T) {
+	visual := withPhase(newTestVisual("diagram"), phasePending)
+	visual.Spec.Style = napkinv1.NapkinStyleSpec{StyleId: "sketch", ColorMode: "dark", Orientation: "vertical"}
+	visual.Spec.Variations = 2
+	visual.Spec.Language = "de"
+	r, napkin, _ := newTestReconciler(t, visual)
+
+	reconcileVisual(t, r, "diagram")
+
+	if len(napkin.submitted()) != 1 {
+		t.Fatalf("expected one submission, got %d", len(napkin.submitted()))
+	}
+	got := getVisual(t, r.Client, "diagram").Status.SubmittedRequest
+	if got == nil {
+		t.Fatal("expected status.submittedRequest to be set after submission")
+	}
+	sum := sha256.Sum256([]byte(visual.Spec.Content))
+	want := napkinv1.SubmittedRequestStatus{
+		ContentSha256: hex.EncodeToString(sum[:]),
+		ContentLength: len(visual.Spec.Content),
+		Format:        visual.Spec.Format,
+		StyleId:       "sketch",
+		ColorMode:     "dark",
+		Orientation:   "vertical",
+		Language:      "de",
+		Variations:    2,
+	}
+	if *got != want {
+		t.Errorf("submittedRequest = %+v, want %+v", *got, want)
+	}
+}
+
+func TestSubmitRequestCarriesOrientation(t *testing.T) {
+	tests := []struct {
+		orientation string
+		want        string
+		wantSent    bool
+	}{
+		{"horizontal", "horizontal", true},
+		{"vertical", "vertical", true},
+		{"square", "square", true},
+		{"auto", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.orientation, func(t *testing.T) {
+			visual := newTestVisual("diagram")
+			visual.Spec.Style.Orientation = tt.orientation
+
+			body, err := json.Marshal(buildSubmitRequest(visual, visual.Spec.Content, ""))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var fields map[string]any
+			if err := json.Unmarshal(body, &fields); err != nil {
+				t.Fatal(err)
+			}
+			got, sent := fields["orientation"]
+			if sent != tt.wantSent || (sent && got != tt.want) {
+				t.Errorf("orientation in %s = %v (sent %v), want %q (sent %v)", body, got, sent, tt.want, tt.wantSent)
+			}
+		})
+	}
+}
+
+func TestPendingVisualSubmitsOrientation(t *testing.T) {
+	visual := withPhase(newTestVisual("diagram"), phasePending)
+	visual.Spec.Style.Orientation = "vertical"
+	r, napkin, _ := newTestReconciler(t, visual)
+
+	reconcileVisual(t, r, "diagram")
+
+	submits := napkin.submitted()
+	if len(submits) != 1 || submits[0].Orientation != "vertical" {
+		t.Errorf("submitted %+v, want one request with orientation vertical", submits)
+	}
+}
+
+// dedupVisual returns a pending visual that deduplicates identical requests
+func dedupVisual(name string) *napkinv1.NapkinVisual {
+	visual := withPhase(newTestVisual(name), phasePending)
+	visual.Spec.Deduplicate = true
+	return visual
+}
+
+func TestDeduplicatedVisualReusesStoredFiles(t *testing.T) {
+	r, napkin, store := newTestReconciler(t, dedupVisual("first"))
+	reconcileVisual(t, r, "first")
+	napkin.complete("req-0", napkin.addFile(0, "svg", "light", svgData))
+	first := reconcileUntil(t, r, "first", phaseCompleted)
+	uploads := store.uploads
+
+	if err := r.Create(context.Background(), dedupVisual("second")); err != nil {
+		t.Fatal(err)
+	}
+	second := reconcileUntil(t, r, "second", phaseCompleted)
+
+	if n := len(napkin.submitted()); n != 1 {
+		t.Errorf("expected only the first visual to be submitted, got %d submissions", n)
+	}
+	if store.uploads != uploads {
+		t.Errorf("expected no uploads for the second visual, got %d", store.uploads-uploads)
+	}
+	if reason := readyReason(second); reason != "Deduplicated" {
+		t.Errorf("Ready reason = %q, want Deduplicated", reason)
+	}
+	if len(second.Status.GeneratedFiles) != 1 || second.Status.GeneratedFiles[0].MinioKey != first.Status.GeneratedFiles[0].MinioKey {
+		t.Errorf("second visual files = %+v, want the first visual's %+v", second.Status.GeneratedFiles, first.Status.GeneratedFiles)
+	}
+	if !strings.Contains(first.Status.GeneratedFiles[0].MinioKey, first.Status.RequestHash) {
+		t.Errorf("key %q does not contain the request hash %q", first.Status.GeneratedFiles[0].MinioKey, first.Status.RequestHash)
+	}
+}
+
+func TestDeduplicationIgnoresIncompleteSets(t *testing.T) {
+	r, napkin, store := newTestReconciler(t, dedupVisual("first"))
+	reconcileVisual(t, r, "first")
+	napkin.complete("req-0", napkin.addFile(0, "svg", "light", svgData), napkin.addFile(1, "svg", "light", svgData))
+	// The second file fails to upload, so the set is left half stored
+	store.uploadErr = func(key string) error {
+		if strings.Contains(key, "/1-") || strings.HasSuffix(key, "/1.svg") {
+			return &storage.TransientError{Err: fmt.Errorf("minio unavailable")}
+		}
+		return nil
+	}
+	for i := 0; i < 3; i++ {
+		reconcileVisual(t, r, "first")
+	}
+	first := getVisual(t, r, "first")
+	if first.Status.Phase == phaseCompleted {
+		t.Fatalf("expected the first visual to be stuck storing, got %s", first.Status.Phase)
+	}
+	if first.Status.GeneratedFiles[0].MinioKey == "" {
+		t.Fatal("expected the first file to be stored")
+	}
+
+	if err := r.Create(context.Background(), dedupVisual("second")); err != nil {
+		t.Fatal(err)
+	}
+	reconcileVisual(t, r, "second")
+
+	if n := len(napkin.submitted()); n != 2 {
+		t.Errorf("expected the second visual to be submitted rather than reuse an incomplete set, got %d submissions", n)
+	}
+}
+
+// setClock fixes the controller clock for the duration of the test
+func setClock(t *testing.T, now time.Time) {
+	t.Helper()
+	clock = func() time.Time { return now }
+	t.Cleanup(func() { clock = time.Now })
+}
+
+func TestKeyTemplateUsesStartDate(t *testing.T) {
+	setClock(t, time.Date(2026, 3, 9, 23, 45, 0, 0, time.UTC))
+	visual := newTestVisual("diagram")
+	visual.Spec.TenantId = "acme"
+	visual.Spec.Storage.KeyTemplate = "{{.date}}/{{.tenant}}/{{.name}}/{{.index}}.{{.format}}"
+	r, napkin, store := newTestReconciler(t, visual)
+
+	reconcileVisual(t, r, "diagram")
+	// Generation finishes the next day; the key keeps the start date
+	setClock(t, time.Date(2026, 3, 10, 0, 15, 0, 0, time.UTC))
+	reconcileVisual(t, r, "diagram")
+	napkin.complete("req-0", napkin.addFile(0, "svg", "light", svgData))
+	reconcileUntil(t, r, "diagram", phaseCompleted)
+
+	if keys := store.keys(); len(keys) != 1 || keys[0] != "napkin-visuals/2026/03/09/acme/diagram/0.svg" {
+		t.Errorf("stored keys = %v, want the start date partition", keys)
+	}
+}
+
+func TestKeyTemplateFallsBackToClock(t *testing.T) {
+	setClock(t, time.Date(2026, 7, 4, 12, 0, 0, 0, time.UTC))
+	r, napkin, store := newTestReconciler(t)
+	visual := downloadingVisual(napkin, "diagram", 1)
+	visual.Spec.Storage.KeyTemplate = "{{.date}}/{{.name}}-{{.index}}.{{.format}}"
+	visual.Status.StartTime = nil
+	if err := r.Create(context.Background(), visual); err != nil {
+		t.Fatal(err)
+	}
+
+	reconcileUntil(t, r, "diagram", phaseCompleted)
+
+	if keys := store.keys(); len(keys) != 1 || !strings.HasSuffix(keys[0], "/2026/07/04/diagram-0.svg") {
+		t.Errorf("stored keys = %v, want a key for 2026/07/04", keys)
+	}
+}
+
+func TestStorageOutageRetriesWithoutRedownloading(t *testing.T) {
+	r, napkin, store := newTestReconciler(t)
+	visual := downloadingVisual(napkin, "diagram", 2)
+	if err := r.Create(context.Background(), visual); err != nil {
+		t.Fatal(err)
+	}
+	down := true
+	store.uploadErr = func(key string) error {
+		if down {
+			return &storage.TransientError{Err: fmt.Errorf("dial tcp: connection refused")}
+		}
+		return nil
+	}
+
+	for attempt := 1; attempt <= 2; attempt++ {
+		if result := reconcileVisual(t, r, "diagram"); result.RequeueAfter == 0 {
+			t.Errorf("attempt %d: expected a delayed retry", attempt)
+		}
+		got := getVisual(t, r, "diagram")
+		if got.Status.Phase != phaseUploading || readyReason(got) != "StorageUnavailable" {
+			t.Fatalf("attempt %d: expected Uploading/StorageUnavailable, got %s/%s", attempt, got.Status.Phase, readyReason(got))
+		}
+		if got.Status.StorageAttempts != attempt || got.Status.RetryCount != 0 {
+			t.Errorf("attempt %d: storageAttempts=%d retryCount=%d", attempt, got.Status.StorageAttempts, got.Status.RetryCount)
+		}
+	}
+
+	// The Napkin URLs expire, so recovery must use the staged downloads
+	napkin.mu.Lock()
+	napkin.files = map[string][]byte{}
+	napkin.mu.Unlock()
+	down = false
+	reconcileVisual(t, r, "diagram")
+
+	got := getVisual(t, r, "diagram")
+	if got.Status.Phase != phaseCompleted {
+		t.Fatalf("expected Completed after storage recovered, got %s (%s)", got.Status.Phase, got.Status.LastError)
+	}
+	if keys := store.keys(); len(keys) != 2 {
+		t.Errorf("expected both files stored, got %v", keys)
+	}
+	if got.Status.RetryCount != 0 {
+		t.Errorf("retryCount = %d, want 0", got.Status.RetryCount)
+	}
+}
+
+func TestStorageOutageFailsAfterMaxAttempts(t *testing.T) {
+	r, napkin, store := newTestReconciler(t)
+	r.MaxStorageAttempts = 2
+	if err := r.Create(context.Background(), downloadingVisual(napkin, "diagram", 1)); err != nil {
+		t.Fatal(err)
+	}
+	store.uploadErr = func(key string) error {
+		return &storage.TransientError{Err: fmt.Errorf("503 Service Unavailable")}
+	}
+
+	reconcileVisual(t, r, "diagram")
+	reconcileVisual(t, r, "diagram")
+
+	got := getVisual(t, r, "diagram")
+	if got.Status.Phase != phaseFailed || !strings.Contains(got.Status.Conditions[0].Message, "after 2 attempts") {
+		t.Errorf("expected Failed after 2 storage attempts, got %s: %+v", got.Status.Phase, got.Status.Conditions)
+	}
+}
+
+func TestPermanentStorageErrorFailsImmediately(t *testing.T) {
+	r, napkin, store := newTestReconciler(t)
+	if err := r.Create(context.Background(), downloadingVisual(napkin, "diagram", 1)); err != nil {
+		t.Fatal(err)
+	}
+	store.uploadErr = func(key string) error {
+		return fmt.Errorf("invalid object tags: tag value contains invalid characters")
+	}
+
+	reconcileVisual(t, r, "diagram")
+
+	got := getVisual(t, r, "diagram")
+	if got.Status.Phase != phaseFailed || got.Status.StorageAttempts != 0 {
+		t.Errorf("expected Failed without storage retries, got %s with %d storage attempts", got.Status.Phase, got.Status.StorageAttempts)
+	}
+	if _, ok := r.staged.get(got.UID, 0); ok {
+		t.Error("expected nothing staged for a permanent failure")
+	}
+}
+
+func TestBucketErrorsAreRetriedOnlyWhenTransient(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		wantPhase string
+	}{
+		{"access denied", fmt.Errorf("Access Denied"), phaseFailed},
+		{"connection reset", &storage.TransientError{Err: fmt.Errorf("connection reset")}, phaseDownloading},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, napkin, store := newTestReconciler(t)
+			if err := r.Create(context.Background(), downloadingVisual(napkin, "diagram", 1)); err != nil {
+				t.Fatal(err)
+			}
+			store.ensureErr = tt.err
+
+			reconcileVisual(t, r, "diagram")
+
+			if got := getVisual(t, r, "diagram"); got.Status.Phase != tt.wantPhase {
+				t.Errorf("expected %s, got %s", tt.wantPhase, got.Status.Phase)
+			}
+		})
+	}
+}
+
+func TestTransientBucketErrorStaysInDownloading(t *testing.T) {
+	r, napkin, store := newTestReconciler(t)
+	if err := r.Create(context.Background(), downloadingVisual(napkin, "diagram", 1)); err != nil {
+		t.Fatal(err)
+	}
+	store.ensureErr = &storage.TransientError{Err: fmt.Errorf("connection refused")}
+
+	result := reconcileVisual(t, r, "diagram")
+
+	got := getVisual(t, r, "diagram")
+	if got.Status.Phase != phaseDownloading || readyReason(got) != "StorageUnavailable" || got.Status.StorageAttempts != 1 {
+		t.Errorf("expected Downloading with one storage attempt, got %s (%s) with %d attempts", got.Status.Phase, readyReason(got), got.Status.StorageAttempts)
+	}
+	if result.RequeueAfter == 0 {
+		t.Error("expected a delayed retry")
+	}
+	if _, ok := r.staged.get(got.UID, 0); ok {
+		t.Error("expected nothing staged before the bucket is ready")
+	}
+
+	store.ensureErr = nil
+	reconcileVisual(t, r, "diagram")
+
+	got = getVisual(t, r, "diagram")
+	if got.Status.Phase != phaseCompleted || got.Status.GeneratedFiles[0].MinioKey == "" {
+		t.Errorf("expected the files to be downloaded and stored on retry, got %s: %+v", got.Status.Phase, got.Status.GeneratedFiles)
+	}
+}
+
+func TestBothColorModesStoreDistinctKeys(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(visual *napkinv1.NapkinVisual)
+	}{
+		{name: "default layout", mutate: func(visual *napkinv1.NapkinVisual) {}},
+		{name: "deduplicated", mutate: func(visual *napkinv1.NapkinVisual) { visual.Spec.Deduplicate = true }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			visual := withPhase(newTestVisual("diagram"), phasePending)
+			visual.Spec.TenantId = "acme"
+			visual.Spec.Style.ColorMode = "both"
+			tt.mutate(visual)
+			r, napkin, store := newTestReconciler(t, visual)
+			reconcileVisual(t, r, "diagram")
+			napkin.complete("req-0",
+				napkin.addFile(0, "svg", "light", svgData),
+				napkin.addFile(0, "svg", "dark", svgData))
+
+			got := reconcileUntil(t, r, "diagram", phaseCompleted)
+
+			if len(got.Status.GeneratedFiles) != 2 {
+				t.Fatalf("expected two generated files, got %+v", got.Status.GeneratedFiles)
+			}
+			modes := map[string]string{}
+			for _, file := range got.Status.GeneratedFiles {
+				modes[file.ColorMode] = file.MinioKey
+			}
+			light, dark := modes["light"], modes["dark"]
+			if light == "" || dark == "" || light == dark {
+				t.Errorf("expected distinct light and dark keys, got %+v", got.Status.GeneratedFiles)
+			}
+			if !strings.HasSuffix(light, "/0-light.svg") || !strings.HasSuffix(dark, "/0-dark.svg") {
+				t.Errorf("keys %q and %q don't name their color mode", light, dark)
+			}
+			stored := 0
+			for _, key := range store.keys() {
+				if strings.HasSuffix(key, ".svg") {
+					stored++
+				}
+			}
+			if stored != 2 {
+				t.Errorf("expected two stored files, got %v", store.keys())
+			}
+		})
+	}
+}
+
+func TestGetContentType(t *testing.T) {
+	pngHeader := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	tests := []struct {
+		format string
+		data   []byte
+		want   string
+	}{
+		{"svg", svgData, "image/svg+xml"},
+		{"png", pngHeader, "image/png"},
+		{"jpg", nil, "image/jpeg"},
+		{"jpeg", nil, "image/jpeg"},
+		{"webp", nil, "image/webp"},
+		{"pdf", nil, "application/pdf"},
+		{"ppt", nil, "application/vnd.ms-powerpoint"},
+		// Unrecognized formats are sniffed from the content
+		{"bin", pngHeader, "image/png"},
+		{"", []byte("GIF89a\x01\x00\x01\x00"), "image/gif"},
+		{"img", []byte("\xff\xd8\xff\xe0\x00\x10JFIF\x00"), "image/jpeg"},
+		{"doc", []byte("%PDF-1.7\n"), "application/pdf"},
+		{"txt", []byte("plain notes"), "text/plain; charset=utf-8"},
+		{"dat", []byte{0x00, 0x01, 0x02, 0x03}, "application/octet-stream"},
+	}
+	for _, tt := range tests {
+		if got := getContentType(tt.format, tt.data); got != tt.want {
+			t.Errorf("getContentType(%q, %q) = %q, want %q", tt.format, tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestPendingVisualSubmitsDimensions(t *testing.T) {
+	visual := withPhase(newTestVisual("diagram"), phasePending)
+	visual.Spec.Format = "png"
+	visual.Spec.Width, visual.Spec.Height, visual.Spec.DPI = 1920, 1080, 300
+	r, napkin, _ := newTestReconciler(t, visual)
+
+	reconcileVisual(t, r, "diagram")
+
+	submits := napkin.submitted()
+	if len(submits) != 1 {
+		t.Fatalf("expected one submission, got %d", len(submits))
+	}
+	if got := submits[0]; got.Width != 1920 || got.Height != 1080 || got.DPI != 300 {
+		t.Errorf("submitted width=%d height=%d dpi=%d, want 1920x1080 at 300", got.Width, got.Height, got.DPI)
+	}
+
+	body, err := json.Marshal(buildSubmitRequest(visual, visual.Spec.Content, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(body, &fields); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]float64{"width": 1920, "height": 1080, "dpi": 300} {
+		if fields[key] != want {
+			t.Errorf("%s in %s = %v, want %v", key, body, fields[key], want)
+		}
+	}
+
+	// Unset dimensions are left to Napkin
+	visual.Spec.Width, visual.Spec.Height, visual.Spec.DPI = 0, 0, 0
+	body, _ = json.Marshal(buildSubmitRequest(visual, visual.Spec.Content, ""))
+	for _, key := range []string{`"width"`, `"height"`, `"dpi"`} {
+		if strings.Contains(string(body), key) {
+			t.Errorf("expected %s to be omitted from %s", key, body)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	napkinv1 "github.com/Tributary-ai-services/napkin-operator/api/v1"
+)
+
+// quotaRequeueDelay is how long a visual waits before rechecking its tenant's quota
+const quotaRequeueDelay = 30 * time.Second
+
+// tenantLocks serializes quota admission per tenant
+type tenantLocks struct {
+	locks sync.Map // tenant ID -> *sync.Mutex
+}
+
+// lock blocks until the tenant's admission lock is held and returns its unlock
+func (t *tenantLocks) lock(tenant string) func() {
+	mu, _ := t.locks.LoadOrStore(tenant, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	return mu.(*sync.Mutex).Unlock
+}
+
+// admitTenant serializes the quota check and submission of pending visuals
+// of the same tenant, so concurrent reconciles can't each see room under
+// the cap and submit together. The returned func releases it. Admission is
+// only serialized within this operator process, which leader election keeps
+// to a single active instance.
+func (r *NapkinVisualReconciler) admitTenant(visual *napkinv1.NapkinVisual) func() {
+	if r.MaxInflightPerTenant <= 0 {
+		return func() {}
+	}
+	return r.tenants.lock(visual.Spec.TenantId)
+}
+
+// waitForTenantQuota reports whether a pending visual must wait because its
+// tenant already has MaxInflightPerTenant generations in flight. While
+// waiting the visual carries a QuotaExceeded condition.
+func (r *NapkinVisualReconciler) waitForTenantQuota(ctx context.Context, visual *napkinv1.NapkinVisual) (ctrl.Result, bool) {
+	if r.MaxInflightPerTenant <= 0 {
+		return ctrl.Result{}, false
+	}
+	logger := log.FromContext(ctx)
+
+	// The cache may not show submissions made by the previous admission yet
+	var visuals napkinv1.NapkinVisualList
+	if err := r.reader().List(ctx, &visuals); err != nil {
+		logger.Error(err, "Failed to list NapkinVisuals for tenant quota")
+		return ctrl.Result{RequeueAfter: quotaRequeueDelay}, true
+	}
+
+	inflight := 0
+	for i := range visuals.Items {
+		other := &visuals.Items[i]
+		if other.UID == visual.UID || other.Spec.TenantId != visual.Spec.TenantId {
+			continue
+		}
+		switch other.Status.Phase {
+		case phaseSubmitted, phaseProcessing, phaseDownloading, phaseUploading:
+			inflight++
+		case phasePending:
+			// A batch stays Pending until every item is submitted
+			for _, item := range other.Status.BatchItems {
+				if item.NapkinRequestId != "" {
+					inflight++
+					break
+				}
+			}
+		}
+	}
+
+	if inflight < r.MaxInflightPerTenant {
+		// Cleared in memory; the next status update in the Pending path persists it
+		removeCondition(visual, "QuotaExceeded")
+		return ctrl.Result{}, false
+	}
+
+	if findCondition(visual, "QuotaExceeded") == nil {
+		logger.Info("Tenant quota reached, waiting", "tenant", visual.Spec.TenantId, "inflight", inflight)
+		setCondition(visual, napkinv1.NapkinVisualCondition{
+			Type:               "QuotaExceeded",
+			Status:             "True",
+			LastTransitionTime: metav1.Now(),
+			Reason:             "TenantQuotaReached",
+			Message:            fmt.Sprintf("Tenant %q has %d generations in flight (limit %d)", visual.Spec.TenantId, inflight, r.MaxInflightPerTenant),
+		})
+		if err := r.Status().Update(ctx, visual); err != nil {
+			logger.Error(err, "Failed to update status")
+		}
+	}
+	return ctrl.Result{RequeueAfter: quotaRequeueDelay}, true
+}
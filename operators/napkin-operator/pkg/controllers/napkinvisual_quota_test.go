@@ -0,0 +1,93 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	napkinv1 "github.com/Tributary-ai-services/napkin-operator/api/v1"
+)
+
+// tenantVisual returns a pending visual for the given tenant
+func tenantVisual(name, tenant string) *napkinv1.NapkinVisual {
+	visual := withPhase(newTestVisual(name), phasePending)
+	visual.Spec.TenantId = tenant
+	return visual
+}
+
+func TestTenantQuotaHoldsUnderConcurrentReconciles(t *testing.T) {
+	const limit, n = 2, 6
+	var objs []client.Object
+	for i := 0; i < n; i++ {
+		objs = append(objs, tenantVisual(fmt.Sprintf("acme-%d", i), "acme"))
+	}
+	objs = append(objs, tenantVisual("globex-0", "globex"))
+	r, napkin, _ := newTestReconciler(t, objs...)
+	r.MaxInflightPerTenant = limit
+	// Widen the window between the quota check and the status write
+	napkin.submitDelay = 20 * time.Millisecond
+
+	var wg sync.WaitGroup
+	for _, obj := range objs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reconcileVisual(t, r, obj.GetName())
+		}()
+	}
+	wg.Wait()
+
+	submitted, waiting := 0, 0
+	for i := 0; i < n; i++ {
+		visual := getVisual(t, r, fmt.Sprintf("acme-%d", i))
+		switch {
+		case visual.Status.Phase == phaseSubmitted:
+			submitted++
+		case visual.Status.Phase == phasePending && findCondition(visual, "QuotaExceeded") != nil:
+			waiting++
+		default:
+			t.Errorf("acme-%d: unexpected phase %s", i, visual.Status.Phase)
+		}
+	}
+	if submitted != limit || waiting != n-limit {
+		t.Errorf("acme: %d submitted and %d waiting, want %d and %d", submitted, waiting, limit, n-limit)
+	}
+	if phase := getVisual(t, r, "globex-0").Status.Phase; phase != phaseSubmitted {
+		t.Errorf("globex-0: expected another tenant to be unaffected, got %s", phase)
+	}
+	if got := len(napkin.submitted()); got != limit+1 {
+		t.Errorf("expected %d submissions, got %d", limit+1, got)
+	}
+}
+
+func TestTenantQuotaAdmitsWaitingVisualWhenSlotFrees(t *testing.T) {
+	running := withPhase(tenantVisual("running", "acme"), phaseProcessing)
+	r, napkin, _ := newTestReconciler(t, running, tenantVisual("waiting", "acme"))
+	r.MaxInflightPerTenant = 1
+
+	if result := reconcileVisual(t, r, "waiting"); result.RequeueAfter != quotaRequeueDelay {
+		t.Errorf("RequeueAfter = %v, want %v", result.RequeueAfter, quotaRequeueDelay)
+	}
+	if len(napkin.submitted()) != 0 {
+		t.Fatal("expected the waiting visual not to be submitted")
+	}
+
+	running = getVisual(t, r, "running")
+	running.Status.Phase = phaseCompleted
+	if err := r.Status().Update(context.Background(), running); err != nil {
+		t.Fatal(err)
+	}
+	reconcileVisual(t, r, "waiting")
+
+	visual := getVisual(t, r, "waiting")
+	if visual.Status.Phase != phaseSubmitted {
+		t.Errorf("expected Submitted once the slot freed, got %s", visual.Status.Phase)
+	}
+	if findCondition(visual, "QuotaExceeded") != nil {
+		t.Error("expected the QuotaExceeded condition to be removed")
+	}
+}
@@ -0,0 +1,49 @@
+package controllers
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// stagedFiles keeps downloaded files whose upload to storage failed, so a
+// later attempt can upload them without downloading from Napkin again (the
+// Napkin URLs may have expired by then). Entries live in memory only.
+type stagedFiles struct {
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+func stagedKey(uid types.UID, index int) string {
+	return fmt.Sprintf("%s/%d", uid, index)
+}
+
+func (s *stagedFiles) put(uid types.UID, index int, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.files == nil {
+		s.files = make(map[string][]byte)
+	}
+	s.files[stagedKey(uid, index)] = data
+}
+
+func (s *stagedFiles) get(uid types.UID, index int) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.files[stagedKey(uid, index)]
+	return data, ok
+}
+
+// clear drops all staged files of a visual
+func (s *stagedFiles) clear(uid types.UID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prefix := string(uid) + "/"
+	for key := range s.files {
+		if strings.HasPrefix(key, prefix) {
+			delete(s.files, key)
+		}
+	}
+}
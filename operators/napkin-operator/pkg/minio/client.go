@@ -3,63 +3,423 @@ package minio
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/lifecycle"
+	"github.com/minio/minio-go/v7/pkg/tags"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/Tributary-ai-services/napkin-operator/pkg/storage"
 )
 
 var tracer = otel.Tracer("minio-client")
 
-// Client is the MinIO storage client
+const (
+	// expirationRuleID prefixes the IDs of the lifecycle rules managed by the
+	// operator; it was also the ID of the unscoped rule of earlier versions
+	expirationRuleID = "napkin-operator-expiration"
+
+	// minPartSize is the smallest multipart part S3 accepts; objects larger
+	// than the configured part size are uploaded in parts
+	minPartSize = 5 << 20
+
+	// defaultUploadThreads is the number of parts uploaded in parallel
+	defaultUploadThreads = 4
+
+	// bucketCacheTTL is how long a bucket is remembered as existing before
+	// EnsureBucket checks MinIO again
+	bucketCacheTTL = 5 * time.Minute
+)
+
+// SanitizeBucketName converts name into a valid S3 bucket name: lowercase
+// letters, digits and dashes, starting and ending with an alphanumeric
+// character, between 3 and 63 characters long. A name that has to be changed
+// gets a short hash of the original appended, so names that differ only in
+// case, punctuation or beyond the length limit map to different buckets.
+func SanitizeBucketName(name string) string {
+	var b strings.Builder
+	lastDash := false
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+			lastDash = false
+		} else if !lastDash {
+			b.WriteByte('-')
+			lastDash = true
+		}
+	}
+
+	sanitized := strings.Trim(b.String(), "-")
+	if sanitized == name && len(sanitized) >= 3 && len(sanitized) <= 63 {
+		return sanitized
+	}
+
+	sum := sha256.Sum256([]byte(name))
+	suffix := hex.EncodeToString(sum[:])[:8]
+	if len(sanitized) > 63-len(suffix)-1 {
+		sanitized = strings.TrimRight(sanitized[:63-len(suffix)-1], "-")
+	}
+	if sanitized == "" {
+		return suffix
+	}
+	return sanitized + "-" + suffix
+}
+
+var _ storage.Storage = &Client{}
+
+// Client is the MinIO storage client. It is safe for concurrent use once
+// configured; SetPublicURL must be called before the client is shared.
 type Client struct {
 	client    *minio.Client
 	endpoint  string
+	useSSL    bool
 	publicURL string // Public-facing base URL for generated links (e.g. "https://minio.tas.scharber.com")
+
+	partSize      uint64
+	uploadThreads uint
+
+	bucketsMu       sync.Mutex
+	knownBuckets    map[string]time.Time          // bucket -> time its existence was confirmed
+	knownLifecycles map[bucketLifecycle]time.Time // bucket and expiry -> time its rule was applied
+}
+
+// bucketLifecycle identifies an expiration rule applied to a bucket
+type bucketLifecycle struct {
+	bucket string
+	days   int
+}
+
+// options collects the MinIO connection options and upload tuning
+type options struct {
+	minio.Options
+	partSize      uint64
+	uploadThreads uint
+}
+
+// Option configures the MinIO client
+type Option func(*options)
+
+// WithRegion sets the bucket region instead of relying on region discovery
+func WithRegion(region string) Option {
+	return func(o *options) {
+		o.Region = region
+	}
+}
+
+// WithPathStyle forces path-style bucket addressing (endpoint/bucket/key)
+// for S3-compatible stores that don't support virtual-host addressing
+func WithPathStyle(pathStyle bool) Option {
+	return func(o *options) {
+		if pathStyle {
+			o.BucketLookup = minio.BucketLookupPath
+		}
+	}
+}
+
+// WithInsecureSkipVerify disables TLS certificate verification. Only use this
+// for in-cluster MinIO with a self-signed certificate when WithCACert isn't an option.
+func WithInsecureSkipVerify(skip bool) Option {
+	return func(o *options) {
+		if skip {
+			transportTLSConfig(&o.Options).InsecureSkipVerify = true
+		}
+	}
+}
+
+// WithCACert trusts the PEM-encoded CA certificates in addition to the system roots
+func WithCACert(pem []byte) Option {
+	return func(o *options) {
+		if len(pem) == 0 {
+			return
+		}
+		cfg := transportTLSConfig(&o.Options)
+		if cfg.RootCAs == nil {
+			pool, err := x509.SystemCertPool()
+			if err != nil {
+				pool = x509.NewCertPool()
+			}
+			cfg.RootCAs = pool
+		}
+		cfg.RootCAs.AppendCertsFromPEM(pem)
+	}
+}
+
+// WithPartSize sets the multipart part size in bytes. Objects larger than the
+// part size are uploaded in parts; values below the S3 minimum of 5 MiB are raised to it.
+func WithPartSize(size uint64) Option {
+	return func(o *options) {
+		if size < minPartSize {
+			size = minPartSize
+		}
+		o.partSize = size
+	}
+}
+
+// WithUploadConcurrency sets the number of parts uploaded in parallel
+func WithUploadConcurrency(threads int) Option {
+	return func(o *options) {
+		if threads > 0 {
+			o.uploadThreads = uint(threads)
+		}
+	}
+}
+
+// transportTLSConfig returns the TLS config of the options' HTTP transport,
+// installing a copy of the MinIO default transport if none is set yet
+func transportTLSConfig(o *minio.Options) *tls.Config {
+	transport, ok := o.Transport.(*http.Transport)
+	if !ok {
+		transport, _ = minio.DefaultTransport(true)
+		o.Transport = transport
+	}
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	return transport.TLSClientConfig
 }
 
 // NewClient creates a new MinIO client
-func NewClient(endpoint, accessKey, secretKey string, useSSL bool) (*Client, error) {
-	client, err := minio.New(endpoint, &minio.Options{
-		Creds:  credentials.NewStaticV4(accessKey, secretKey, ""),
-		Secure: useSSL,
-	})
+func NewClient(endpoint, accessKey, secretKey string, useSSL bool, opts ...Option) (*Client, error) {
+	o := &options{
+		Options: minio.Options{
+			Creds:  credentials.NewStaticV4(accessKey, secretKey, ""),
+			Secure: useSSL,
+		},
+		partSize:      minPartSize,
+		uploadThreads: defaultUploadThreads,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	client, err := minio.New(endpoint, &o.Options)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create MinIO client: %w", err)
 	}
 
 	return &Client{
-		client:   client,
-		endpoint: endpoint,
+		client:          client,
+		endpoint:        endpoint,
+		useSSL:          useSSL,
+		partSize:        o.partSize,
+		uploadThreads:   o.uploadThreads,
+		knownBuckets:    make(map[string]time.Time),
+		knownLifecycles: make(map[bucketLifecycle]time.Time),
 	}, nil
 }
 
 // SetPublicURL sets the public-facing URL used for generating download links.
 // If set, Upload() will return URLs using this base instead of the internal endpoint.
+// The base may contain a "{bucket}" placeholder for virtual-host style links
+// (e.g. "https://{bucket}.cdn.example.com"); otherwise the bucket is added to the path.
 func (c *Client) SetPublicURL(url string) {
-	c.publicURL = url
+	c.publicURL = strings.TrimRight(url, "/")
+}
+
+// ObjectURL returns the download URL for an object, using the public URL when configured
+func (c *Client) ObjectURL(bucket, key string) string {
+	key = strings.TrimLeft(key, "/")
+
+	if c.publicURL != "" {
+		if strings.Contains(c.publicURL, "{bucket}") {
+			return fmt.Sprintf("%s/%s", strings.ReplaceAll(c.publicURL, "{bucket}", bucket), key)
+		}
+		return fmt.Sprintf("%s/%s/%s", c.publicURL, bucket, key)
+	}
+
+	scheme := "http"
+	if c.useSSL {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s/%s/%s", scheme, c.endpoint, bucket, key)
 }
 
-// EnsureBucket creates a bucket if it doesn't exist
-func (c *Client) EnsureBucket(ctx context.Context, bucket string) error {
+// EnsureBucket creates a bucket if it doesn't exist. If expireAfterDays is
+// positive, a lifecycle rule expiring the objects tagged with that value is
+// applied to the bucket as well.
+func (c *Client) EnsureBucket(ctx context.Context, bucket string, expireAfterDays int) error {
 	ctx, span := tracer.Start(ctx, "minio_ensure_bucket")
 	defer span.End()
 	span.SetAttributes(attribute.String("minio.bucket", bucket))
 
-	exists, err := c.client.BucketExists(ctx, bucket)
-	if err != nil {
-		span.RecordError(err)
-		return fmt.Errorf("failed to check bucket existence: %w", err)
+	if !c.bucketKnown(bucket) {
+		exists, err := c.client.BucketExists(ctx, bucket)
+		if err != nil {
+			span.RecordError(err)
+			return classifyError(fmt.Errorf("failed to check bucket existence: %w", err))
+		}
+
+		if !exists {
+			if err := c.client.MakeBucket(ctx, bucket, minio.MakeBucketOptions{}); err != nil {
+				span.RecordError(err)
+				return classifyError(fmt.Errorf("failed to create bucket: %w", err))
+			}
+		}
+		c.rememberBucket(bucket)
+	}
+
+	if expireAfterDays > 0 && !c.lifecycleKnown(bucket, expireAfterDays) {
+		if err := c.SetBucketLifecycle(ctx, bucket, expireAfterDays); err != nil {
+			return err
+		}
+		c.rememberLifecycle(bucket, expireAfterDays)
+	}
+
+	return nil
+}
+
+// bucketKnown reports whether the bucket was confirmed to exist within bucketCacheTTL
+func (c *Client) bucketKnown(bucket string) bool {
+	c.bucketsMu.Lock()
+	defer c.bucketsMu.Unlock()
+	confirmed, ok := c.knownBuckets[bucket]
+	return ok && time.Since(confirmed) < bucketCacheTTL
+}
+
+func (c *Client) rememberBucket(bucket string) {
+	c.bucketsMu.Lock()
+	defer c.bucketsMu.Unlock()
+	c.knownBuckets[bucket] = time.Now()
+}
+
+// lifecycleKnown reports whether the expiration rule for days was applied to
+// the bucket within bucketCacheTTL
+func (c *Client) lifecycleKnown(bucket string, days int) bool {
+	c.bucketsMu.Lock()
+	defer c.bucketsMu.Unlock()
+	applied, ok := c.knownLifecycles[bucketLifecycle{bucket, days}]
+	return ok && time.Since(applied) < bucketCacheTTL
+}
+
+func (c *Client) rememberLifecycle(bucket string, days int) {
+	c.bucketsMu.Lock()
+	defer c.bucketsMu.Unlock()
+	c.knownLifecycles[bucketLifecycle{bucket, days}] = time.Now()
+}
+
+// forgetBucket drops the bucket and its applied lifecycle rules from the
+// cache so the next EnsureBucket checks MinIO again, e.g. after an upload
+// failed because the bucket was removed
+func (c *Client) forgetBucket(bucket string) {
+	c.bucketsMu.Lock()
+	defer c.bucketsMu.Unlock()
+	delete(c.knownBuckets, bucket)
+	for key := range c.knownLifecycles {
+		if key.bucket == bucket {
+			delete(c.knownLifecycles, key)
+		}
+	}
+}
+
+// SetBucketLifecycle configures objects tagged with storage.ExpireAfterDaysTag
+// set to days to expire after that many days. There is one rule per distinct
+// number of days, so visuals sharing a bucket don't overwrite each other's
+// expiry. Other lifecycle rules on the bucket are preserved, the unscoped rule
+// of earlier versions is removed, and the configuration is only written when
+// it actually changes.
+func (c *Client) SetBucketLifecycle(ctx context.Context, bucket string, days int) error {
+	ctx, span := tracer.Start(ctx, "minio_set_bucket_lifecycle")
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("minio.bucket", bucket),
+		attribute.Int("minio.expire_days", days),
+	)
+
+	if days <= 0 {
+		return fmt.Errorf("expiration days must be positive, got %d", days)
 	}
 
-	if !exists {
-		if err := c.client.MakeBucket(ctx, bucket, minio.MakeBucketOptions{}); err != nil {
+	config, err := c.client.GetBucketLifecycle(ctx, bucket)
+	if err != nil {
+		if minio.ToErrorResponse(err).Code != "NoSuchLifecycleConfiguration" {
 			span.RecordError(err)
-			return fmt.Errorf("failed to create bucket: %w", err)
+			return classifyError(fmt.Errorf("failed to get bucket lifecycle: %w", err))
+		}
+		config = lifecycle.NewConfiguration()
+	}
+
+	rule := expirationRule(days)
+
+	changed, found := false, false
+	rules := config.Rules[:0]
+	for _, existing := range config.Rules {
+		switch existing.ID {
+		case expirationRuleID:
+			// The unscoped rule expired every object in the bucket
+			changed = true
+			continue
+		case rule.ID:
+			found = true
+			if !sameExpirationRule(existing, rule) {
+				existing, changed = rule, true
+			}
 		}
+		rules = append(rules, existing)
+	}
+	if !found {
+		rules = append(rules, rule)
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	config.Rules = rules
+
+	if err := c.client.SetBucketLifecycle(ctx, bucket, config); err != nil {
+		span.RecordError(err)
+		return classifyError(fmt.Errorf("failed to set bucket lifecycle: %w", err))
+	}
+
+	return nil
+}
+
+// expirationRule returns the lifecycle rule expiring objects tagged for the given number of days
+func expirationRule(days int) lifecycle.Rule {
+	return lifecycle.Rule{
+		ID:     fmt.Sprintf("%s-%dd", expirationRuleID, days),
+		Status: "Enabled",
+		RuleFilter: lifecycle.Filter{
+			Tag: lifecycle.Tag{Key: storage.ExpireAfterDaysTag, Value: strconv.Itoa(days)},
+		},
+		Expiration: lifecycle.Expiration{
+			Days: lifecycle.ExpirationDays(days),
+		},
+	}
+}
+
+// sameExpirationRule reports whether two expiration rules have the same effect
+func sameExpirationRule(a, b lifecycle.Rule) bool {
+	return a.Status == b.Status &&
+		a.Expiration.Days == b.Expiration.Days &&
+		a.RuleFilter.Tag.Key == b.RuleFilter.Tag.Key &&
+		a.RuleFilter.Tag.Value == b.RuleFilter.Tag.Value
+}
+
+// Ping verifies MinIO is reachable by checking whether the bucket exists.
+// A missing bucket is not an error since it is created on first upload.
+func (c *Client) Ping(ctx context.Context, bucket string) error {
+	ctx, span := tracer.Start(ctx, "minio_ping")
+	defer span.End()
+	span.SetAttributes(attribute.String("minio.bucket", bucket))
+
+	if _, err := c.client.BucketExists(ctx, bucket); err != nil {
+		span.RecordError(err)
+		return fmt.Errorf("failed to reach MinIO: %w", err)
 	}
 
 	return nil
@@ -67,34 +427,71 @@ func (c *Client) EnsureBucket(ctx context.Context, bucket string) error {
 
 // Upload uploads data to MinIO
 func (c *Client) Upload(ctx context.Context, bucket, key string, data []byte, contentType string) (string, error) {
+	return c.UploadWithTags(ctx, bucket, key, data, contentType, nil)
+}
+
+// UploadWithTags uploads data to MinIO and attaches the given object tags.
+// Tags must satisfy S3 limits (at most 10 tags, keys up to 128 and values up
+// to 256 characters from the allowed character set).
+func (c *Client) UploadWithTags(ctx context.Context, bucket, key string, data []byte, contentType string, objectTags map[string]string) (string, error) {
+	return c.putObject(ctx, bucket, key, bytes.NewReader(data), int64(len(data)), contentType, objectTags)
+}
+
+// putObject uploads an object and returns its download URL
+func (c *Client) putObject(ctx context.Context, bucket, key string, r io.Reader, size int64, contentType string, objectTags map[string]string) (string, error) {
 	ctx, span := tracer.Start(ctx, "minio_upload")
 	defer span.End()
 	span.SetAttributes(
 		attribute.String("minio.bucket", bucket),
 		attribute.String("minio.key", key),
-		attribute.Int("minio.size", len(data)),
+		attribute.Int64("minio.size", size),
+		attribute.Int("minio.tags", len(objectTags)),
 	)
 
-	if err := c.EnsureBucket(ctx, bucket); err != nil {
+	if len(objectTags) > 0 {
+		if _, err := tags.NewTags(objectTags, true); err != nil {
+			span.RecordError(err)
+			return "", fmt.Errorf("invalid object tags: %w", err)
+		}
+	}
+
+	if err := c.EnsureBucket(ctx, bucket, 0); err != nil {
 		return "", err
 	}
 
-	reader := bytes.NewReader(data)
-	_, err := c.client.PutObject(ctx, bucket, key, reader, int64(len(data)), minio.PutObjectOptions{
+	_, err := c.client.PutObject(ctx, bucket, key, r, size, minio.PutObjectOptions{
 		ContentType: contentType,
+		UserTags:    objectTags,
+		PartSize:    c.partSize,
+		NumThreads:  c.uploadThreads,
 	})
 	if err != nil {
 		span.RecordError(err)
-		return "", fmt.Errorf("failed to upload to MinIO: %w", err)
+		c.forgetBucket(bucket)
+		return "", classifyError(fmt.Errorf("failed to upload to MinIO: %w", err))
 	}
 
-	var url string
-	if c.publicURL != "" {
-		url = fmt.Sprintf("%s/%s/%s", c.publicURL, bucket, key)
-	} else {
-		url = fmt.Sprintf("http://%s/%s/%s", c.endpoint, bucket, key)
+	return c.ObjectURL(bucket, key), nil
+}
+
+// classifyError marks failures worth retrying as storage.TransientError:
+// transport errors, 5xx responses, throttling, and a bucket that disappeared
+// after it was cached as existing. Anything else is returned unchanged.
+func classifyError(err error) error {
+	var resp minio.ErrorResponse
+	if errors.As(err, &resp) {
+		switch {
+		case resp.StatusCode >= 500, resp.Code == "SlowDown", resp.Code == "RequestTimeout", resp.Code == "NoSuchBucket":
+			return &storage.TransientError{Err: err}
+		}
+		return err
 	}
-	return url, nil
+
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, context.DeadlineExceeded) {
+		return &storage.TransientError{Err: err}
+	}
+	return err
 }
 
 // Download downloads data from MinIO
@@ -109,19 +506,43 @@ func (c *Client) Download(ctx context.Context, bucket, key string) ([]byte, erro
 	obj, err := c.client.GetObject(ctx, bucket, key, minio.GetObjectOptions{})
 	if err != nil {
 		span.RecordError(err)
-		return nil, fmt.Errorf("failed to get object from MinIO: %w", err)
+		return nil, classifyError(fmt.Errorf("failed to get object from MinIO: %w", err))
 	}
 	defer obj.Close()
 
 	data, err := io.ReadAll(obj)
 	if err != nil {
 		span.RecordError(err)
-		return nil, fmt.Errorf("failed to read object data: %w", err)
+		return nil, classifyError(fmt.Errorf("failed to read object data: %w", err))
 	}
 
 	return data, nil
 }
 
+// List returns the objects under prefix. A missing bucket yields no objects.
+func (c *Client) List(ctx context.Context, bucket, prefix string) ([]storage.ObjectInfo, error) {
+	ctx, span := tracer.Start(ctx, "minio_list")
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("minio.bucket", bucket),
+		attribute.String("minio.prefix", prefix),
+	)
+
+	var objects []storage.ObjectInfo
+	for obj := range c.client.ListObjects(ctx, bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if obj.Err != nil {
+			if minio.ToErrorResponse(obj.Err).Code == "NoSuchBucket" {
+				return nil, nil
+			}
+			span.RecordError(obj.Err)
+			return nil, classifyError(fmt.Errorf("failed to list objects: %w", obj.Err))
+		}
+		objects = append(objects, storage.ObjectInfo{Key: obj.Key, Size: obj.Size})
+	}
+
+	return objects, nil
+}
+
 // Delete deletes an object from MinIO
 func (c *Client) Delete(ctx context.Context, bucket, key string) error {
 	ctx, span := tracer.Start(ctx, "minio_delete")
@@ -134,7 +555,7 @@ func (c *Client) Delete(ctx context.Context, bucket, key string) error {
 	err := c.client.RemoveObject(ctx, bucket, key, minio.RemoveObjectOptions{})
 	if err != nil {
 		span.RecordError(err)
-		return fmt.Errorf("failed to delete object from MinIO: %w", err)
+		return classifyError(fmt.Errorf("failed to delete object from MinIO: %w", err))
 	}
 
 	return nil
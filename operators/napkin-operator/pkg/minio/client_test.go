@@ -0,0 +1,693 @@
+package minio
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/pem"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/lifecycle"
+	"github.com/minio/minio-go/v7/pkg/tags"
+
+	"github.com/Tributary-ai-services/napkin-operator/pkg/storage"
+)
+
+// fakeS3 is a minimal path-style S3 server covering the calls the client makes
+type fakeS3 struct {
+	mu        sync.Mutex
+	buckets   map[string]bool
+	objects   map[string][]byte // bucket/key -> data
+	headers   map[string]http.Header
+	lifecycle map[string][]byte   // bucket -> lifecycle XML
+	requests  []string            // "METHOD /path?query"
+	parts     map[string][][]byte // upload ID -> parts by number - 1
+
+	// fail returns a status code to fail the request with, or 0 to serve it.
+	// 5xx statuses report InternalError, others AccessDenied.
+	fail func(r *http.Request) int
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, *httptest.Server) {
+	t.Helper()
+	f := &fakeS3{
+		buckets:   make(map[string]bool),
+		objects:   make(map[string][]byte),
+		headers:   make(map[string]http.Header),
+		lifecycle: make(map[string][]byte),
+		parts:     make(map[string][][]byte),
+	}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return f, srv
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, r.Method+" "+r.URL.Path+"?"+r.URL.RawQuery)
+
+	if f.fail != nil {
+		if status := f.fail(r); status != 0 {
+			code := "InternalError"
+			if status < 500 {
+				code = "AccessDenied"
+			}
+			s3Error(w, status, code)
+			return
+		}
+	}
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	query := r.URL.Query()
+	switch {
+	case key == "" && query.Has("lifecycle"):
+		f.serveLifecycle(w, r, bucket)
+	case key == "" && r.Method == http.MethodHead:
+		if !f.buckets[bucket] {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	case key == "" && r.Method == http.MethodPut:
+		f.buckets[bucket] = true
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		id := fmt.Sprintf("upload-%d", len(f.parts))
+		f.parts[id] = nil
+		f.headers[bucket+"/"+key] = r.Header.Clone()
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><Bucket>%s</Bucket><Key>%s</Key><UploadId>%s</UploadId></InitiateMultipartUploadResult>", bucket, key, id)
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		data, err := readBody(r)
+		if err != nil {
+			s3Error(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		id := query.Get("uploadId")
+		n, _ := strconv.Atoi(query.Get("partNumber"))
+		for len(f.parts[id]) < n {
+			f.parts[id] = append(f.parts[id], nil)
+		}
+		f.parts[id][n-1] = data
+		w.Header().Set("ETag", fmt.Sprintf(`"part-%d"`, n))
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		f.objects[bucket+"/"+key] = bytes.Join(f.parts[query.Get("uploadId")], nil)
+		fmt.Fprintf(w, "<CompleteMultipartUploadResult><Bucket>%s</Bucket><Key>%s</Key><ETag>\"etag\"</ETag></CompleteMultipartUploadResult>", bucket, key)
+	case r.Method == http.MethodPut:
+		data, err := readBody(r)
+		if err != nil {
+			s3Error(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		f.objects[bucket+"/"+key] = data
+		f.headers[bucket+"/"+key] = r.Header.Clone()
+		w.Header().Set("ETag", `"etag"`)
+	case r.Method == http.MethodGet:
+		data, ok := f.objects[bucket+"/"+key]
+		if !ok {
+			s3Error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Write(data)
+	case r.Method == http.MethodDelete:
+		delete(f.objects, bucket+"/"+key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		s3Error(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+func (f *fakeS3) serveLifecycle(w http.ResponseWriter, r *http.Request, bucket string) {
+	switch r.Method {
+	case http.MethodGet:
+		config, ok := f.lifecycle[bucket]
+		if !ok {
+			s3Error(w, http.StatusNotFound, "NoSuchLifecycleConfiguration")
+			return
+		}
+		w.Write(config)
+	case http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		f.lifecycle[bucket] = data
+	}
+}
+
+// count returns the number of requests with the given method whose path and
+// query contain substr
+func (f *fakeS3) count(method, substr string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, req := range f.requests {
+		if strings.HasPrefix(req, method+" ") && strings.Contains(req, substr) {
+			n++
+		}
+	}
+	return n
+}
+
+func (f *fakeS3) rules(t *testing.T, bucket string) []lifecycle.Rule {
+	t.Helper()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var config lifecycle.Configuration
+	if err := xml.Unmarshal(f.lifecycle[bucket], &config); err != nil {
+		t.Fatalf("invalid lifecycle configuration: %v", err)
+	}
+	return config.Rules
+}
+
+// readBody returns the request body, decoding aws-chunked streaming uploads
+func readBody(r *http.Request) ([]byte, error) {
+	if !strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		return io.ReadAll(r.Body)
+	}
+	var data bytes.Buffer
+	br := bufio.NewReader(r.Body)
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		sizeHex, _, _ := strings.Cut(strings.TrimSpace(line), ";")
+		size, err := strconv.ParseInt(sizeHex, 16, 64)
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return data.Bytes(), nil
+		}
+		if _, err := io.CopyN(&data, br, size); err != nil {
+			return nil, err
+		}
+		if _, err := br.ReadString('\n'); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func s3Error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
+
+func newTestClient(t *testing.T, srv *httptest.Server, opts ...Option) *Client {
+	t.Helper()
+	opts = append([]Option{WithRegion("us-east-1")}, opts...)
+	c, err := NewClient(strings.TrimPrefix(srv.URL, "http://"), "access", "secret", false, opts...)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	return c
+}
+
+func TestEnsureBucketScopesExpirationToTaggedObjects(t *testing.T) {
+	fake, srv := newFakeS3(t)
+	c := newTestClient(t, srv)
+	ctx := context.Background()
+
+	if err := c.EnsureBucket(ctx, "visuals", 30); err != nil {
+		t.Fatalf("EnsureBucket: %v", err)
+	}
+	if err := c.EnsureBucket(ctx, "visuals", 7); err != nil {
+		t.Fatalf("EnsureBucket: %v", err)
+	}
+
+	rules := fake.rules(t, "visuals")
+	if len(rules) != 2 {
+		t.Fatalf("expected one rule per expiry, got %+v", rules)
+	}
+	for i, days := range []int{30, 7} {
+		rule := rules[i]
+		if rule.ID != fmt.Sprintf("napkin-operator-expiration-%dd", days) {
+			t.Errorf("rule %d: unexpected ID %q", i, rule.ID)
+		}
+		if int(rule.Expiration.Days) != days {
+			t.Errorf("rule %d: expected %d days, got %d", i, days, rule.Expiration.Days)
+		}
+		if rule.RuleFilter.Tag.Key != "expire-after-days" || rule.RuleFilter.Tag.Value != strconv.Itoa(days) {
+			t.Errorf("rule %d: expected a tag filter, got %+v", i, rule.RuleFilter)
+		}
+	}
+
+	// Reapplying an existing expiry doesn't rewrite the configuration
+	writes := fake.count(http.MethodPut, "lifecycle")
+	if err := c.EnsureBucket(ctx, "visuals", 30); err != nil {
+		t.Fatalf("EnsureBucket: %v", err)
+	}
+	if got := fake.count(http.MethodPut, "lifecycle"); got != writes {
+		t.Errorf("expected no lifecycle write for an unchanged rule, got %d more", got-writes)
+	}
+}
+
+func TestSetBucketLifecycleReplacesUnscopedRule(t *testing.T) {
+	fake, srv := newFakeS3(t)
+	c := newTestClient(t, srv)
+	fake.buckets["visuals"] = true
+	fake.lifecycle["visuals"] = []byte(`<LifecycleConfiguration>` +
+		`<Rule><ID>napkin-operator-expiration</ID><Status>Enabled</Status><Filter></Filter><Expiration><Days>1</Days></Expiration></Rule>` +
+		`<Rule><ID>keep</ID><Status>Enabled</Status><Filter><Prefix>logs/</Prefix></Filter><Expiration><Days>90</Days></Expiration></Rule>` +
+		`</LifecycleConfiguration>`)
+
+	if err := c.SetBucketLifecycle(context.Background(), "visuals", 30); err != nil {
+		t.Fatalf("SetBucketLifecycle: %v", err)
+	}
+
+	rules := fake.rules(t, "visuals")
+	if len(rules) != 2 || rules[0].ID != "keep" || rules[1].ID != "napkin-operator-expiration-30d" {
+		t.Fatalf("expected the unrelated rule and the scoped rule, got %+v", rules)
+	}
+}
+
+func TestSanitizeBucketName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "napkin-visuals-acme", want: "napkin-visuals-acme"},
+		{name: "napkin-visuals-Acme", want: "napkin-visuals-acme-"},
+		{name: "napkin-visuals-acme_corp", want: "napkin-visuals-acme-corp-"},
+		{name: "napkin-visuals-acme--", want: "napkin-visuals-acme-"},
+		{name: "ab", want: "ab-"},
+		{name: "---", want: ""},
+		{name: "napkin-visuals-" + strings.Repeat("tenant", 10), want: "napkin-visuals-tenanttenanttenanttenanttenanttenantten-"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := SanitizeBucketName(tt.name)
+			if !strings.HasPrefix(got, tt.want) {
+				t.Errorf("got %q, want prefix %q", got, tt.want)
+			}
+			if tt.want == tt.name && got != tt.name {
+				t.Errorf("expected a valid name to be kept, got %q", got)
+			}
+			if len(got) < 3 || len(got) > 63 || strings.Trim(got, "abcdefghijklmnopqrstuvwxyz0123456789-") != "" ||
+				strings.HasPrefix(got, "-") || strings.HasSuffix(got, "-") {
+				t.Errorf("%q is not a valid bucket name", got)
+			}
+		})
+	}
+}
+
+func TestSanitizeBucketNameKeepsTenantsApart(t *testing.T) {
+	long := "napkin-visuals-" + strings.Repeat("tenant", 10)
+	for _, names := range [][2]string{
+		{"napkin-visuals-Acme", "napkin-visuals-acme"},
+		{"napkin-visuals-acme_corp", "napkin-visuals-acme.corp"},
+		{long + "-a", long + "-b"},
+	} {
+		if a, b := SanitizeBucketName(names[0]), SanitizeBucketName(names[1]); a == b {
+			t.Errorf("%q and %q both map to bucket %q", names[0], names[1], a)
+		}
+	}
+	if a, b := SanitizeBucketName("napkin-visuals-Acme"), SanitizeBucketName("napkin-visuals-Acme"); a != b {
+		t.Errorf("expected a stable name, got %q and %q", a, b)
+	}
+}
+
+func TestUploadWithTagsSendsTaggingHeader(t *testing.T) {
+	fake, srv := newFakeS3(t)
+	c := newTestClient(t, srv)
+	want := map[string]string{"tenant": "acme", "visual": "diagram", "format": "svg", "color_mode": "dark"}
+
+	if _, err := c.UploadWithTags(context.Background(), "visuals", "acme/diagram/0.svg", []byte("<svg/>"), "image/svg+xml", want); err != nil {
+		t.Fatalf("UploadWithTags: %v", err)
+	}
+
+	fake.mu.Lock()
+	header := fake.headers["visuals/acme/diagram/0.svg"].Get("X-Amz-Tagging")
+	fake.mu.Unlock()
+	got, err := url.ParseQuery(header)
+	if err != nil {
+		t.Fatalf("invalid X-Amz-Tagging header %q: %v", header, err)
+	}
+	if len(got) != len(want) {
+		t.Errorf("expected %d tags, got %q", len(want), header)
+	}
+	for key, value := range want {
+		if got.Get(key) != value {
+			t.Errorf("tag %s: got %q, want %q", key, got.Get(key), value)
+		}
+	}
+}
+
+func TestUploadWithTagsRejectsInvalidTags(t *testing.T) {
+	fake, srv := newFakeS3(t)
+	c := newTestClient(t, srv)
+
+	_, err := c.UploadWithTags(context.Background(), "visuals", "key", []byte("data"), "text/plain",
+		map[string]string{"tenant": strings.Repeat("a", 257)})
+	if err == nil || !strings.Contains(err.Error(), "invalid object tags") {
+		t.Fatalf("expected an invalid tag error, got %v", err)
+	}
+	if got := fake.count(http.MethodPut, "visuals/key"); got != 0 {
+		t.Errorf("expected no upload with invalid tags, got %d", got)
+	}
+}
+
+func TestRegionAndPathStyleOptions(t *testing.T) {
+	apply := func(opts ...Option) *options {
+		o := &options{}
+		for _, opt := range opts {
+			opt(o)
+		}
+		return o
+	}
+
+	if o := apply(); o.Region != "" || o.BucketLookup != minio.BucketLookupAuto {
+		t.Errorf("expected region discovery and automatic bucket lookup by default, got %q, %v", o.Region, o.BucketLookup)
+	}
+	if o := apply(WithRegion("eu-west-1"), WithPathStyle(true)); o.Region != "eu-west-1" || o.BucketLookup != minio.BucketLookupPath {
+		t.Errorf("expected region eu-west-1 with path-style lookup, got %q, %v", o.Region, o.BucketLookup)
+	}
+	if o := apply(WithPathStyle(false)); o.BucketLookup != minio.BucketLookupAuto {
+		t.Errorf("expected WithPathStyle(false) to keep automatic lookup, got %v", o.BucketLookup)
+	}
+}
+
+func TestConfiguredRegionSkipsRegionLookup(t *testing.T) {
+	fake, srv := newFakeS3(t)
+	c := newTestClient(t, srv, WithRegion("eu-west-1"), WithPathStyle(true))
+
+	if _, err := c.UploadWithTags(context.Background(), "visuals", "acme/diagram/0.svg", []byte("<svg/>"), "image/svg+xml", nil); err != nil {
+		t.Fatalf("UploadWithTags: %v", err)
+	}
+	if got := fake.count(http.MethodGet, "location"); got != 0 {
+		t.Errorf("expected no bucket location lookups with a configured region, got %d", got)
+	}
+	if got := fake.count(http.MethodPut, "/visuals/acme/diagram/0.svg"); got != 1 {
+		t.Errorf("expected a path-style PUT of the object, got requests %v", fake.requests)
+	}
+	fake.mu.Lock()
+	auth := fake.headers["visuals/acme/diagram/0.svg"].Get("Authorization")
+	fake.mu.Unlock()
+	if !strings.Contains(auth, "/eu-west-1/s3/") {
+		t.Errorf("expected requests signed for eu-west-1, got %q", auth)
+	}
+}
+
+func TestObjectURLScheme(t *testing.T) {
+	for _, tt := range []struct {
+		useSSL bool
+		want   string
+	}{
+		{false, "http://minio.local:9000/visuals/acme/diagram/0.svg"},
+		{true, "https://minio.local:9000/visuals/acme/diagram/0.svg"},
+	} {
+		c, err := NewClient("minio.local:9000", "access", "secret", tt.useSSL, WithRegion("us-east-1"))
+		if err != nil {
+			t.Fatalf("NewClient: %v", err)
+		}
+		if got := c.ObjectURL("visuals", "acme/diagram/0.svg"); got != tt.want {
+			t.Errorf("useSSL %v: got %q, want %q", tt.useSSL, got, tt.want)
+		}
+	}
+}
+
+func TestObjectURLPublicURL(t *testing.T) {
+	tests := []struct {
+		name      string
+		publicURL string
+		key       string
+		want      string
+	}{
+		{name: "unconfigured", key: "acme/0.svg", want: "http://minio.local:9000/visuals/acme/0.svg"},
+		{name: "bucket in path", publicURL: "https://files.example.com", key: "acme/0.svg", want: "https://files.example.com/visuals/acme/0.svg"},
+		{name: "trailing slash", publicURL: "https://files.example.com/", key: "acme/0.svg", want: "https://files.example.com/visuals/acme/0.svg"},
+		{name: "base path", publicURL: "https://example.com/minio/", key: "acme/0.svg", want: "https://example.com/minio/visuals/acme/0.svg"},
+		{name: "bucket in host", publicURL: "https://{bucket}.files.example.com", key: "acme/0.svg", want: "https://visuals.files.example.com/acme/0.svg"},
+		{name: "leading slash in key", publicURL: "https://files.example.com", key: "/acme/0.svg", want: "https://files.example.com/visuals/acme/0.svg"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewClient("minio.local:9000", "access", "secret", false, WithRegion("us-east-1"))
+			if err != nil {
+				t.Fatalf("NewClient: %v", err)
+			}
+			if tt.publicURL != "" {
+				c.SetPublicURL(tt.publicURL)
+			}
+			if got := c.ObjectURL("visuals", tt.key); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUploadReturnsPublicURL(t *testing.T) {
+	_, srv := newFakeS3(t)
+	c := newTestClient(t, srv)
+	c.SetPublicURL("https://files.example.com/")
+
+	got, err := c.UploadWithTags(context.Background(), "visuals", "acme/0.svg", []byte("<svg/>"), "image/svg+xml", nil)
+	if err != nil {
+		t.Fatalf("UploadWithTags: %v", err)
+	}
+	if want := "https://files.example.com/visuals/acme/0.svg"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestTLSVerification(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    func(caPEM []byte) []Option
+		wantErr bool
+	}{
+		{name: "verification by default", opts: func([]byte) []Option { return nil }, wantErr: true},
+		{name: "skip verification", opts: func([]byte) []Option { return []Option{WithInsecureSkipVerify(true)} }},
+		{name: "custom CA", opts: func(caPEM []byte) []Option { return []Option{WithCACert(caPEM)} }},
+		{name: "skip disabled", opts: func([]byte) []Option { return []Option{WithInsecureSkipVerify(false)} }, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeS3{
+				buckets:   make(map[string]bool),
+				objects:   make(map[string][]byte),
+				headers:   make(map[string]http.Header),
+				lifecycle: make(map[string][]byte),
+				parts:     make(map[string][][]byte),
+			}
+			srv := httptest.NewUnstartedServer(fake)
+			srv.Config.ErrorLog = log.New(io.Discard, "", 0)
+			srv.StartTLS()
+			defer srv.Close()
+			caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+
+			opts := append([]Option{WithRegion("us-east-1")}, tt.opts(caPEM)...)
+			c, err := NewClient(strings.TrimPrefix(srv.URL, "https://"), "access", "secret", true, opts...)
+			if err != nil {
+				t.Fatalf("NewClient: %v", err)
+			}
+
+			// Bound the client's retries of the handshake failure
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			url, err := c.UploadWithTags(ctx, "visuals", "acme/0.svg", []byte("<svg/>"), "image/svg+xml", nil)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected the self-signed certificate to be rejected")
+				}
+				if got := len(fake.requests); got != 0 {
+					t.Errorf("expected no request past the TLS handshake, got %v", fake.requests)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("UploadWithTags: %v", err)
+			}
+			if !strings.HasPrefix(url, "https://") {
+				t.Errorf("expected an https URL, got %q", url)
+			}
+		})
+	}
+}
+
+func TestUploadWithTagsUsesMultipartAbovePartSize(t *testing.T) {
+	fake, srv := newFakeS3(t)
+	c := newTestClient(t, srv, WithPartSize(minPartSize), WithUploadConcurrency(2))
+	data := bytes.Repeat([]byte("0123456789abcdef"), (2*minPartSize+1024)/16)
+	ctx := context.Background()
+
+	if _, err := c.UploadWithTags(ctx, "visuals", "acme/deck.pptx", data, "application/vnd.openxmlformats-officedocument.presentationml.presentation",
+		map[string]string{"format": "ppt"}); err != nil {
+		t.Fatalf("UploadWithTags: %v", err)
+	}
+
+	if got := fake.count(http.MethodPut, "partNumber="); got != 3 {
+		t.Errorf("expected 3 parts, got %d", got)
+	}
+	fake.mu.Lock()
+	stored := fake.objects["visuals/acme/deck.pptx"]
+	tagging := fake.headers["visuals/acme/deck.pptx"].Get("X-Amz-Tagging")
+	fake.mu.Unlock()
+	if !bytes.Equal(stored, data) {
+		t.Errorf("expected the parts to reassemble the data, got %d of %d bytes", len(stored), len(data))
+	}
+	if tagging != "format=ppt" {
+		t.Errorf("expected the tags on the multipart upload, got %q", tagging)
+	}
+
+	// Small objects use a single PUT
+	if _, err := c.UploadWithTags(ctx, "visuals", "acme/0.svg", []byte("<svg/>"), "image/svg+xml", nil); err != nil {
+		t.Fatalf("UploadWithTags: %v", err)
+	}
+	if got := fake.count(http.MethodPost, "acme/0.svg"); got != 0 {
+		t.Errorf("expected a single PUT for a small object, got %d multipart requests", got)
+	}
+}
+
+func TestUploadsShareBucketExistenceCheck(t *testing.T) {
+	fake, srv := newFakeS3(t)
+	c := newTestClient(t, srv)
+	ctx := context.Background()
+	bucketChecks := func() int { return fake.count(http.MethodHead, "/visuals/?") }
+
+	const n = 5
+	for i := 0; i < n; i++ {
+		if _, err := c.UploadWithTags(ctx, "visuals", fmt.Sprintf("acme/%d.svg", i), []byte("<svg/>"), "image/svg+xml", nil); err != nil {
+			t.Fatalf("upload %d: %v", i, err)
+		}
+	}
+	if got := bucketChecks(); got != 1 {
+		t.Errorf("expected BucketExists once for %d uploads, got %d", n, got)
+	}
+	if got := fake.count(http.MethodPut, "/visuals/?"); got != 1 {
+		t.Errorf("expected the bucket to be created once, got %d", got)
+	}
+
+	// An expired entry is checked again
+	c.bucketsMu.Lock()
+	c.knownBuckets["visuals"] = time.Now().Add(-bucketCacheTTL)
+	c.bucketsMu.Unlock()
+	if _, err := c.UploadWithTags(ctx, "visuals", "acme/expired.svg", []byte("<svg/>"), "image/svg+xml", nil); err != nil {
+		t.Fatalf("upload after expiry: %v", err)
+	}
+	if got := bucketChecks(); got != 2 {
+		t.Errorf("expected a new check after the TTL, got %d checks", got)
+	}
+
+	// A failed upload forgets the bucket, so the next upload checks it again
+	fake.fail = func(r *http.Request) int {
+		if r.Method == http.MethodPut && strings.HasSuffix(r.URL.Path, "/broken.svg") {
+			return http.StatusBadRequest
+		}
+		return 0
+	}
+	if _, err := c.UploadWithTags(ctx, "visuals", "acme/broken.svg", []byte("<svg/>"), "image/svg+xml", nil); err == nil {
+		t.Fatal("expected the upload to fail")
+	}
+	if _, err := c.UploadWithTags(ctx, "visuals", "acme/next.svg", []byte("<svg/>"), "image/svg+xml", nil); err != nil {
+		t.Fatalf("upload after failure: %v", err)
+	}
+	if got := bucketChecks(); got != 3 {
+		t.Errorf("expected a new check after a failed upload, got %d checks", got)
+	}
+}
+
+func TestClassifyError(t *testing.T) {
+	_, tagErr := tags.NewTags(map[string]string{"bad key\x00": "v"}, true)
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"internal error", minio.ErrorResponse{StatusCode: http.StatusInternalServerError, Code: "InternalError"}, true},
+		{"service unavailable", minio.ErrorResponse{StatusCode: http.StatusServiceUnavailable, Code: "ServiceUnavailable"}, true},
+		{"slow down", minio.ErrorResponse{StatusCode: http.StatusServiceUnavailable, Code: "SlowDown"}, true},
+		{"bucket removed", minio.ErrorResponse{StatusCode: http.StatusNotFound, Code: "NoSuchBucket"}, true},
+		{"access denied", minio.ErrorResponse{StatusCode: http.StatusForbidden, Code: "AccessDenied"}, false},
+		{"invalid argument", minio.ErrorResponse{StatusCode: http.StatusBadRequest, Code: "InvalidArgument"}, false},
+		{"connection refused", &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}, true},
+		{"truncated response", fmt.Errorf("read: %w", io.ErrUnexpectedEOF), true},
+		{"invalid tags", tagErr, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := classifyError(fmt.Errorf("failed to upload to MinIO: %w", tt.err))
+			if got := storage.IsTransient(err); got != tt.want {
+				t.Errorf("IsTransient(%v) = %v, want %v", err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUploadErrorsAreClassified(t *testing.T) {
+	fake, srv := newFakeS3(t)
+	c := newTestClient(t, srv)
+	ctx := context.Background()
+
+	_, err := c.UploadWithTags(ctx, "visuals", "acme/0.svg", []byte("<svg/>"), "image/svg+xml", map[string]string{"bad key\x00": "v"})
+	if err == nil || storage.IsTransient(err) {
+		t.Errorf("expected invalid tags to fail permanently, got %v", err)
+	}
+
+	fake.fail = func(r *http.Request) int {
+		if r.Method == http.MethodPut && strings.Contains(r.URL.Path, "acme/") {
+			return http.StatusForbidden
+		}
+		return 0
+	}
+	_, err = c.UploadWithTags(ctx, "visuals", "acme/0.svg", []byte("<svg/>"), "image/svg+xml", nil)
+	if err == nil || storage.IsTransient(err) {
+		t.Errorf("expected access denied to fail permanently, got %v", err)
+	}
+
+	// An unreachable endpoint is transient
+	down := newTestClient(t, srv)
+	srv.Close()
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	if _, err := down.UploadWithTags(ctx, "visuals", "acme/0.svg", []byte("<svg/>"), "image/svg+xml", nil); !storage.IsTransient(err) {
+		t.Errorf("expected an unreachable endpoint to be transient, got %v", err)
+	}
+}
+
+func TestEnsureBucketCachesAppliedLifecycle(t *testing.T) {
+	fake, srv := newFakeS3(t)
+	c := newTestClient(t, srv)
+	ctx := context.Background()
+	lifecycleReads := func() int { return fake.count(http.MethodGet, "lifecycle") }
+
+	for i := 0; i < 3; i++ {
+		if err := c.EnsureBucket(ctx, "visuals", 30); err != nil {
+			t.Fatalf("EnsureBucket: %v", err)
+		}
+	}
+	if got := lifecycleReads(); got != 1 {
+		t.Errorf("expected the lifecycle to be read once for an unchanged expiry, got %d", got)
+	}
+
+	// A different expiry is applied even though the bucket is cached
+	if err := c.EnsureBucket(ctx, "visuals", 7); err != nil {
+		t.Fatalf("EnsureBucket: %v", err)
+	}
+	if got := lifecycleReads(); got != 2 {
+		t.Errorf("expected a new expiry to be applied, got %d lifecycle reads", got)
+	}
+	if rules := fake.rules(t, "visuals"); len(rules) != 2 {
+		t.Errorf("expected one rule per expiry, got %+v", rules)
+	}
+
+	// Forgetting the bucket also forgets its lifecycle rules
+	c.forgetBucket("visuals")
+	if err := c.EnsureBucket(ctx, "visuals", 30); err != nil {
+		t.Fatalf("EnsureBucket: %v", err)
+	}
+	if got := lifecycleReads(); got != 3 {
+		t.Errorf("expected the lifecycle to be checked again after the bucket was forgotten, got %d reads", got)
+	}
+}
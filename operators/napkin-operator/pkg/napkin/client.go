@@ -11,10 +11,37 @@ import (
 
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/time/rate"
 )
 
 var tracer = otel.Tracer("napkin-client")
 
+// limiter is shared by all clients so concurrent reconciles together stay
+// under the Napkin API rate limit. It is unlimited until SetRateLimit is called.
+var limiter = rate.NewLimiter(rate.Inf, 0)
+
+// SetRateLimit limits Napkin API calls across all clients to perSecond
+// requests per second with the given burst. A non-positive rate disables limiting.
+func SetRateLimit(perSecond float64, burst int) {
+	if perSecond <= 0 {
+		limiter.SetLimit(rate.Inf)
+		return
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	limiter.SetBurst(burst)
+	limiter.SetLimit(rate.Limit(perSecond))
+}
+
+// do sends the request once the shared rate limiter allows it
+func do(hc *http.Client, req *http.Request) (*http.Response, error) {
+	if err := limiter.Wait(req.Context()); err != nil {
+		return nil, fmt.Errorf("rate limiter: %w", err)
+	}
+	return hc.Do(req)
+}
+
 // Client is the Napkin AI API client
 type Client struct {
 	baseURL    string
@@ -53,7 +80,7 @@ func (c *Client) Submit(ctx context.Context, req *SubmitRequest) (*SubmitRespons
 	httpReq.Header.Set("Content-Type", "application/json")
 	httpReq.Header.Set("Authorization", "Bearer "+c.apiKey)
 
-	resp, err := c.httpClient.Do(httpReq)
+	resp, err := do(c.httpClient, httpReq)
 	if err != nil {
 		span.RecordError(err)
 		return nil, fmt.Errorf("failed to submit visual: %w", err)
@@ -89,7 +116,7 @@ func (c *Client) GetStatus(ctx context.Context, requestID string) (*StatusRespon
 
 	httpReq.Header.Set("Authorization", "Bearer "+c.apiKey)
 
-	resp, err := c.httpClient.Do(httpReq)
+	resp, err := do(c.httpClient, httpReq)
 	if err != nil {
 		span.RecordError(err)
 		return nil, fmt.Errorf("failed to get status: %w", err)
@@ -111,6 +138,72 @@ func (c *Client) GetStatus(ctx context.Context, requestID string) (*StatusRespon
 	return &result, nil
 }
 
+// ListStyles lists the visual styles available to the API key
+func (c *Client) ListStyles(ctx context.Context) ([]Style, error) {
+	ctx, span := tracer.Start(ctx, "napkin_list_styles")
+	defer span.End()
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/v1/styles", nil)
+	if err != nil {
+		span.RecordError(err)
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	httpReq.Header.Set("Authorization", "Bearer "+c.apiKey)
+
+	resp, err := do(c.httpClient, httpReq)
+	if err != nil {
+		span.RecordError(err)
+		return nil, fmt.Errorf("failed to list styles: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("napkin API returned status %d: %s", resp.StatusCode, string(respBody))
+	}
+
+	var result ListStylesResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		span.RecordError(err)
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	span.SetAttributes(attribute.Int("napkin.style_count", len(result.Styles)))
+	return result.Styles, nil
+}
+
+// Cancel cancels an in-flight visual generation request
+func (c *Client) Cancel(ctx context.Context, requestID string) error {
+	ctx, span := tracer.Start(ctx, "napkin_cancel")
+	defer span.End()
+	span.SetAttributes(attribute.String("napkin.request_id", requestID))
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodDelete, fmt.Sprintf("%s/v1/visual/%s", c.baseURL, requestID), nil)
+	if err != nil {
+		span.RecordError(err)
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	httpReq.Header.Set("Authorization", "Bearer "+c.apiKey)
+
+	resp, err := do(c.httpClient, httpReq)
+	if err != nil {
+		span.RecordError(err)
+		return fmt.Errorf("failed to cancel visual: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusAccepted, http.StatusNoContent, http.StatusNotFound:
+		// A missing request has already finished or been removed
+		return nil
+	default:
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("napkin API returned status %d: %s", resp.StatusCode, string(respBody))
+	}
+}
+
 // DownloadFile downloads a file from the given URL
 func (c *Client) DownloadFile(ctx context.Context, url string) ([]byte, error) {
 	ctx, span := tracer.Start(ctx, "napkin_download_file")
@@ -123,7 +216,7 @@ func (c *Client) DownloadFile(ctx context.Context, url string) ([]byte, error) {
 	}
 
 	downloadClient := &http.Client{Timeout: 60 * time.Second}
-	resp, err := downloadClient.Do(httpReq)
+	resp, err := do(downloadClient, httpReq)
 	if err != nil {
 		span.RecordError(err)
 		return nil, fmt.Errorf("failed to download file: %w", err)
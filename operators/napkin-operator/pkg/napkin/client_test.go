@@ -0,0 +1,138 @@
+package napkin
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCancel(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		wantErr bool
+	}{
+		{"accepted", http.StatusAccepted, false},
+		{"no content", http.StatusNoContent, false},
+		{"already gone", http.StatusNotFound, false},
+		{"server error", http.StatusInternalServerError, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var method, path, auth string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				method, path, auth = r.Method, r.URL.Path, r.Header.Get("Authorization")
+				w.WriteHeader(tt.status)
+			}))
+			defer srv.Close()
+
+			err := NewClient(srv.URL, "key").Cancel(context.Background(), "req-1")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Cancel error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if method != http.MethodDelete || path != "/v1/visual/req-1" {
+				t.Errorf("expected DELETE /v1/visual/req-1, got %s %s", method, path)
+			}
+			if auth != "Bearer key" {
+				t.Errorf("expected the API key as bearer token, got %q", auth)
+			}
+		})
+	}
+}
+
+func TestListStyles(t *testing.T) {
+	var path, auth string
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, auth = r.URL.Path, r.Header.Get("Authorization")
+		if status != http.StatusOK {
+			http.Error(w, "unavailable", status)
+			return
+		}
+		w.Write([]byte(`{"styles":[{"id":"sketch","name":"Sketch"},{"id":"corporate"}]}`))
+	}))
+	defer srv.Close()
+	c := NewClient(srv.URL, "key")
+
+	styles, err := c.ListStyles(context.Background())
+	if err != nil {
+		t.Fatalf("ListStyles: %v", err)
+	}
+	if len(styles) != 2 || styles[0] != (Style{ID: "sketch", Name: "Sketch"}) || styles[1].ID != "corporate" {
+		t.Errorf("unexpected styles %+v", styles)
+	}
+	if path != "/v1/styles" || auth != "Bearer key" {
+		t.Errorf("expected an authenticated GET /v1/styles, got %s with %q", path, auth)
+	}
+
+	status = http.StatusServiceUnavailable
+	if _, err := c.ListStyles(context.Background()); err == nil {
+		t.Error("expected an error when the styles endpoint is unavailable")
+	}
+}
+
+func TestRateLimitSpacesRequests(t *testing.T) {
+	const perSecond = 20
+	SetRateLimit(perSecond, 1)
+	t.Cleanup(func() { SetRateLimit(0, 0) })
+
+	var mu sync.Mutex
+	var seen []time.Time
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		seen = append(seen, time.Now())
+		mu.Unlock()
+		w.Write([]byte(`{"id":"req-1","status":"pending"}`))
+	}))
+	defer srv.Close()
+
+	// Separate clients share the package limiter, as concurrent reconciles do
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := NewClient(srv.URL, "key").GetStatus(context.Background(), "req-1"); err != nil {
+				t.Errorf("GetStatus: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(seen) != 5 {
+		t.Fatalf("server saw %d requests, want 5", len(seen))
+	}
+	interval := time.Second / perSecond
+	// Allow some slack for scheduling between the limiter and the server
+	minGap := interval * 8 / 10
+	for i := 1; i < len(seen); i++ {
+		if gap := seen[i].Sub(seen[i-1]); gap < minGap {
+			t.Errorf("request %d arrived %v after the previous one, want at least %v", i, gap, minGap)
+		}
+	}
+	if total := seen[len(seen)-1].Sub(seen[0]); total < 4*minGap {
+		t.Errorf("5 requests took %v, want at least %v", total, 4*minGap)
+	}
+}
+
+func TestRateLimitWaitHonoursContext(t *testing.T) {
+	SetRateLimit(1, 1)
+	t.Cleanup(func() { SetRateLimit(0, 0) })
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id":"req-1","status":"pending"}`))
+	}))
+	defer srv.Close()
+	c := NewClient(srv.URL, "key")
+
+	if _, err := c.GetStatus(context.Background(), "req-1"); err != nil {
+		t.Fatalf("GetStatus: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.GetStatus(ctx, "req-1"); err == nil {
+		t.Error("expected the second call to give up waiting for the limiter")
+	}
+}
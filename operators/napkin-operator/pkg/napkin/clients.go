@@ -0,0 +1,80 @@
+package napkin
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// clientIdleTTL is how long a cached client may go unused before it is
+// evicted, e.g. after its API key was rotated
+const clientIdleTTL = time.Hour
+
+// ClientCache hands out one Client per API key so that reconciles reuse the
+// same HTTP transport and its pooled keep-alive connections. Clients are
+// keyed by a hash of the key, so a rotated key gets a new client, and clients
+// unused for clientIdleTTL are evicted.
+type ClientCache struct {
+	baseURL    string
+	httpClient *http.Client
+	mu         sync.Mutex
+	clients    map[string]*cachedClient // API key hash -> client
+}
+
+type cachedClient struct {
+	client   *Client
+	lastUsed time.Time
+}
+
+// NewClientCache creates a client cache for the given Napkin API base URL
+func NewClientCache(baseURL string) *ClientCache {
+	return &ClientCache{
+		baseURL: baseURL,
+		httpClient: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+		clients: make(map[string]*cachedClient),
+	}
+}
+
+// Get returns the cached client for apiKey, creating it on first use
+func (cc *ClientCache) Get(apiKey string) *Client {
+	sum := sha256.Sum256([]byte(apiKey))
+	key := hex.EncodeToString(sum[:])
+	now := time.Now()
+
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	cc.evictIdle(now)
+	if entry, ok := cc.clients[key]; ok {
+		entry.lastUsed = now
+		return entry.client
+	}
+
+	c := &Client{
+		baseURL:    cc.baseURL,
+		apiKey:     apiKey,
+		httpClient: cc.httpClient,
+	}
+	cc.clients[key] = &cachedClient{client: c, lastUsed: now}
+	return c
+}
+
+// evictIdle drops the clients unused for clientIdleTTL and closes the idle
+// connections they may have left in the shared transport; connections still
+// needed are dialed again on the next request. cc.mu must be held.
+func (cc *ClientCache) evictIdle(now time.Time) {
+	evicted := false
+	for key, entry := range cc.clients {
+		if now.Sub(entry.lastUsed) >= clientIdleTTL {
+			delete(cc.clients, key)
+			evicted = true
+		}
+	}
+	if evicted {
+		cc.httpClient.CloseIdleConnections()
+	}
+}
@@ -0,0 +1,77 @@
+package napkin
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClientCacheReusesClientPerAPIKey(t *testing.T) {
+	cache := NewClientCache("https://api.napkin.example")
+
+	first := cache.Get("key-a")
+	if again := cache.Get("key-a"); again != first {
+		t.Error("expected the same client for the same API key")
+	}
+	rotated := cache.Get("key-b")
+	if rotated == first || rotated.apiKey != "key-b" {
+		t.Errorf("expected a new client for a rotated API key, got %+v", rotated)
+	}
+	if rotated.httpClient != first.httpClient {
+		t.Error("expected clients to share the HTTP client")
+	}
+	if cache.Get("key-a") != first {
+		t.Error("expected the original key's client to stay cached")
+	}
+	for hash := range cache.clients {
+		if hash == "key-a" || hash == "key-b" {
+			t.Errorf("expected clients to be keyed by a hash, found the raw key %q", hash)
+		}
+	}
+}
+
+func TestClientCacheConcurrentGet(t *testing.T) {
+	cache := NewClientCache("https://api.napkin.example")
+	clients := make([]*Client, 16)
+
+	var wg sync.WaitGroup
+	for i := range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			clients[i] = cache.Get("key")
+		}()
+	}
+	wg.Wait()
+
+	for i, c := range clients {
+		if c != clients[0] {
+			t.Fatalf("goroutine %d got a different client", i)
+		}
+	}
+}
+
+func TestClientCacheEvictsIdleClients(t *testing.T) {
+	cache := NewClientCache("https://api.napkin.example")
+	rotated := cache.Get("old-key")
+	current := cache.Get("new-key")
+
+	// The old key hasn't been used for longer than the idle TTL
+	cache.mu.Lock()
+	for _, entry := range cache.clients {
+		if entry.client == rotated {
+			entry.lastUsed = time.Now().Add(-clientIdleTTL)
+		}
+	}
+	cache.mu.Unlock()
+
+	if cache.Get("new-key") != current {
+		t.Error("expected the client in use to stay cached")
+	}
+	if len(cache.clients) != 1 {
+		t.Errorf("expected the idle client to be evicted, %d clients cached", len(cache.clients))
+	}
+	if cache.Get("old-key") == rotated {
+		t.Error("expected a new client for a key used again after eviction")
+	}
+}
@@ -0,0 +1,76 @@
+package napkin
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// styleErrorTTL is how long a failure to list styles is cached, so an
+// unavailable styles endpoint doesn't use up the rate limit needed for submits
+const styleErrorTTL = 30 * time.Second
+
+// StyleCache caches the styles available to each API key for a fixed TTL so
+// that StyleId validation doesn't hit the Napkin API on every reconcile.
+// Failures are cached too, for at most styleErrorTTL.
+type StyleCache struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]styleCacheEntry
+}
+
+type styleCacheEntry struct {
+	styles    []Style
+	err       error
+	fetchedAt time.Time
+}
+
+// NewStyleCache creates a new style cache with the given TTL
+func NewStyleCache(ttl time.Duration) *StyleCache {
+	return &StyleCache{
+		ttl:     ttl,
+		entries: make(map[string]styleCacheEntry),
+	}
+}
+
+// Styles returns the styles available to the client's API key, fetching them
+// from the Napkin API when the cached list is missing or expired. A failed
+// fetch is returned again until styleErrorTTL has passed.
+func (sc *StyleCache) Styles(ctx context.Context, c *Client) ([]Style, error) {
+	key := styleKey(c)
+
+	sc.mu.Lock()
+	entry, ok := sc.entries[key]
+	sc.mu.Unlock()
+	if ok && entry.err != nil && time.Since(entry.fetchedAt) < min(sc.ttl, styleErrorTTL) {
+		return nil, entry.err
+	}
+	if ok && entry.err == nil && time.Since(entry.fetchedAt) < sc.ttl {
+		return entry.styles, nil
+	}
+
+	styles, err := c.ListStyles(ctx)
+	if err != nil {
+		// Don't cache the caller giving up
+		if ctx.Err() == nil {
+			sc.mu.Lock()
+			sc.entries[key] = styleCacheEntry{err: err, fetchedAt: time.Now()}
+			sc.mu.Unlock()
+		}
+		return nil, err
+	}
+
+	sc.mu.Lock()
+	sc.entries[key] = styleCacheEntry{styles: styles, fetchedAt: time.Now()}
+	sc.mu.Unlock()
+
+	return styles, nil
+}
+
+// styleKey identifies the client's API key without keeping the key itself
+func styleKey(c *Client) string {
+	sum := sha256.Sum256([]byte(c.apiKey))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,86 @@
+package napkin
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStyleCacheFetchesOncePerKeyWithinTTL(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Write([]byte(`{"styles":[{"id":"sketch"}]}`))
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+
+	cache := NewStyleCache(time.Minute)
+	for i := 0; i < 3; i++ {
+		if _, err := cache.Styles(ctx, NewClient(srv.URL, "key-a")); err != nil {
+			t.Fatalf("Styles: %v", err)
+		}
+	}
+	if got := requests.Load(); got != 1 {
+		t.Errorf("expected one request within the TTL, got %d", got)
+	}
+
+	if _, err := cache.Styles(ctx, NewClient(srv.URL, "key-b")); err != nil {
+		t.Fatalf("Styles: %v", err)
+	}
+	if got := requests.Load(); got != 2 {
+		t.Errorf("expected a separate request for another API key, got %d", got)
+	}
+
+	expired := NewStyleCache(0)
+	expired.Styles(ctx, NewClient(srv.URL, "key-a"))
+	expired.Styles(ctx, NewClient(srv.URL, "key-a"))
+	if got := requests.Load(); got != 4 {
+		t.Errorf("expected expired entries to be refetched, got %d requests", got)
+	}
+}
+
+func TestStyleCacheCachesFailuresBriefly(t *testing.T) {
+	var requests atomic.Int32
+	var failing atomic.Bool
+	failing.Store(true)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if failing.Load() {
+			http.Error(w, "styles unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"styles":[{"id":"sketch"}]}`))
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+	c := NewClient(srv.URL, "key-a")
+
+	cache := NewStyleCache(time.Minute)
+	for i := 0; i < 3; i++ {
+		if _, err := cache.Styles(ctx, c); err == nil {
+			t.Fatal("expected the failure to be returned")
+		}
+	}
+	if got := requests.Load(); got != 1 {
+		t.Errorf("expected the failure to be cached, got %d requests", got)
+	}
+
+	// Once the failure expires the styles are fetched again
+	failing.Store(false)
+	cache.mu.Lock()
+	entry := cache.entries[styleKey(c)]
+	entry.fetchedAt = time.Now().Add(-styleErrorTTL)
+	cache.entries[styleKey(c)] = entry
+	cache.mu.Unlock()
+	styles, err := cache.Styles(ctx, c)
+	if err != nil || len(styles) != 1 {
+		t.Fatalf("expected the styles after the failure expired, got %v, %v", styles, err)
+	}
+	if got := requests.Load(); got != 2 {
+		t.Errorf("expected one more request after the failure expired, got %d", got)
+	}
+}
@@ -2,13 +2,17 @@ package napkin
 
 // SubmitRequest is the request body for visual generation
 type SubmitRequest struct {
-	Content    string `json:"content"`
-	Format     string `json:"format,omitempty"`
-	StyleId    string `json:"style_id,omitempty"`
-	ColorMode  string `json:"color_mode,omitempty"`
-	Language   string `json:"language,omitempty"`
-	Variations int    `json:"variations,omitempty"`
-	Context    string `json:"context,omitempty"`
+	Content     string `json:"content"`
+	Format      string `json:"format,omitempty"`
+	StyleId     string `json:"style_id,omitempty"`
+	ColorMode   string `json:"color_mode,omitempty"`
+	Orientation string `json:"orientation,omitempty"`
+	Language    string `json:"language,omitempty"`
+	Variations  int    `json:"variations,omitempty"`
+	Width       int    `json:"width,omitempty"`
+	Height      int    `json:"height,omitempty"`
+	DPI         int    `json:"dpi,omitempty"`
+	Context     string `json:"context,omitempty"`
 }
 
 // SubmitResponse is the response from visual submission
@@ -20,13 +24,14 @@ type SubmitResponse struct {
 
 // StatusResponse is the response from status polling
 type StatusResponse struct {
-	ID          string     `json:"id"`
-	Status      string     `json:"status"`
-	Progress    int        `json:"progress,omitempty"`
-	Files       []FileInfo `json:"files,omitempty"`
-	Error       string     `json:"error,omitempty"`
-	CreatedAt   string     `json:"created_at"`
-	CompletedAt string     `json:"completed_at,omitempty"`
+	ID               string     `json:"id"`
+	Status           string     `json:"status"`
+	Progress         int        `json:"progress,omitempty"`
+	EstimatedSeconds int        `json:"estimated_seconds,omitempty"`
+	Files            []FileInfo `json:"files,omitempty"`
+	Error            string     `json:"error,omitempty"`
+	CreatedAt        string     `json:"created_at"`
+	CompletedAt      string     `json:"completed_at,omitempty"`
 }
 
 // FileInfo describes a generated file
@@ -38,3 +43,14 @@ type FileInfo struct {
 	SizeBytes int64  `json:"size_bytes,omitempty"`
 	ExpiresAt string `json:"expires_at,omitempty"`
 }
+
+// Style describes a Napkin AI visual style
+type Style struct {
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+}
+
+// ListStylesResponse is the response from listing available styles
+type ListStylesResponse struct {
+	Styles []Style `json:"styles"`
+}
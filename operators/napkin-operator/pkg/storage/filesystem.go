@@ -0,0 +1,170 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Filesystem stores objects as files under a root directory, one
+// subdirectory per bucket. It is intended for local development with a
+// PersistentVolume; tags and expiration are not supported.
+type Filesystem struct {
+	root      string
+	publicURL string
+}
+
+var _ Storage = &Filesystem{}
+
+// NewFilesystem creates a filesystem backend rooted at root. If publicURL is
+// set, object URLs are {publicURL}/{bucket}/{key}; otherwise file:// URLs.
+func NewFilesystem(root, publicURL string) (*Filesystem, error) {
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create storage root: %w", err)
+	}
+	return &Filesystem{
+		root:      root,
+		publicURL: strings.TrimRight(publicURL, "/"),
+	}, nil
+}
+
+// objectPath maps bucket and key to a path under the root, rejecting keys
+// that would escape the bucket directory
+func (f *Filesystem) objectPath(bucket, key string) (string, error) {
+	if bucket == "" || strings.ContainsAny(bucket, `/\`) || bucket == "." || bucket == ".." {
+		return "", fmt.Errorf("invalid bucket name %q", bucket)
+	}
+	clean := path.Clean("/" + key)
+	if clean == "/" {
+		return "", fmt.Errorf("invalid object key %q", key)
+	}
+	return filepath.Join(f.root, bucket, filepath.FromSlash(clean)), nil
+}
+
+// EnsureBucket creates the bucket directory; expireAfterDays is ignored
+func (f *Filesystem) EnsureBucket(ctx context.Context, bucket string, expireAfterDays int) error {
+	if _, err := f.objectPath(bucket, "x"); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Join(f.root, bucket), 0o755); err != nil {
+		return &TransientError{Err: fmt.Errorf("failed to create bucket directory: %w", err)}
+	}
+	return nil
+}
+
+// UploadWithTags writes data to the object's file; tags are ignored. I/O
+// errors, e.g. from a full or unmounted volume, are transient.
+func (f *Filesystem) UploadWithTags(ctx context.Context, bucket, key string, data []byte, contentType string, objectTags map[string]string) (string, error) {
+	p, err := f.objectPath(bucket, key)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return "", &TransientError{Err: fmt.Errorf("failed to create object directory: %w", err)}
+	}
+
+	// Write to a temporary file first so readers never see partial objects
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".upload-*")
+	if err != nil {
+		return "", &TransientError{Err: fmt.Errorf("failed to create object file: %w", err)}
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return "", &TransientError{Err: fmt.Errorf("failed to write object: %w", err)}
+	}
+	if err := tmp.Close(); err != nil {
+		return "", &TransientError{Err: fmt.Errorf("failed to write object: %w", err)}
+	}
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		return "", &TransientError{Err: fmt.Errorf("failed to store object: %w", err)}
+	}
+
+	return f.ObjectURL(bucket, key), nil
+}
+
+// Download reads the object's file
+func (f *Filesystem) Download(ctx context.Context, bucket, key string) ([]byte, error) {
+	p, err := f.objectPath(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read object: %w", err)
+	}
+	return data, nil
+}
+
+// Delete removes the object's file; deleting a missing object succeeds
+func (f *Filesystem) Delete(ctx context.Context, bucket, key string) error {
+	p, err := f.objectPath(bucket, key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to delete object: %w", err)
+	}
+	return nil
+}
+
+// List returns the objects whose keys start with prefix
+func (f *Filesystem) List(ctx context.Context, bucket, prefix string) ([]ObjectInfo, error) {
+	if _, err := f.objectPath(bucket, "x"); err != nil {
+		return nil, err
+	}
+	bucketDir := filepath.Join(f.root, bucket)
+
+	var objects []ObjectInfo
+	err := filepath.WalkDir(bucketDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".upload-") {
+			return nil
+		}
+		rel, err := filepath.Rel(bucketDir, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, ObjectInfo{Key: key, Size: info.Size()})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list objects: %w", err)
+	}
+	return objects, nil
+}
+
+// ObjectURL returns the public URL of the object, or a file:// URL
+func (f *Filesystem) ObjectURL(bucket, key string) string {
+	key = strings.TrimLeft(key, "/")
+	if f.publicURL != "" {
+		return fmt.Sprintf("%s/%s/%s", f.publicURL, bucket, key)
+	}
+	return "file://" + filepath.ToSlash(filepath.Join(f.root, bucket, key))
+}
+
+// Ping checks that the root directory is accessible
+func (f *Filesystem) Ping(ctx context.Context, bucket string) error {
+	if _, err := os.Stat(f.root); err != nil {
+		return fmt.Errorf("storage root unavailable: %w", err)
+	}
+	return nil
+}
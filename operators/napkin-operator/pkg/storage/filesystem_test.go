@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func newTestFilesystem(t *testing.T, publicURL string) (*Filesystem, string) {
+	t.Helper()
+	root := filepath.Join(t.TempDir(), "visuals")
+	fsys, err := NewFilesystem(root, publicURL)
+	if err != nil {
+		t.Fatalf("NewFilesystem: %v", err)
+	}
+	return fsys, root
+}
+
+func TestFilesystemRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	fsys, root := newTestFilesystem(t, "")
+	if err := fsys.EnsureBucket(ctx, "napkin", 30); err != nil {
+		t.Fatalf("EnsureBucket: %v", err)
+	}
+
+	data := []byte("<svg></svg>")
+	url, err := fsys.UploadWithTags(ctx, "napkin", "acme/diagram/0.svg", data, "image/svg+xml", map[string]string{"tenant": "acme"})
+	if err != nil {
+		t.Fatalf("UploadWithTags: %v", err)
+	}
+	wantURL := "file://" + filepath.ToSlash(filepath.Join(root, "napkin", "acme", "diagram", "0.svg"))
+	if url != wantURL {
+		t.Errorf("url = %q, want %q", url, wantURL)
+	}
+
+	got, err := fsys.Download(ctx, "napkin", "acme/diagram/0.svg")
+	if err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("downloaded %q, want %q", got, data)
+	}
+
+	// Overwriting replaces the content without leaving temporary files behind
+	if _, err := fsys.UploadWithTags(ctx, "napkin", "acme/diagram/0.svg", []byte("<svg/>"), "image/svg+xml", nil); err != nil {
+		t.Fatalf("UploadWithTags: %v", err)
+	}
+	if got, _ := fsys.Download(ctx, "napkin", "acme/diagram/0.svg"); string(got) != "<svg/>" {
+		t.Errorf("downloaded %q after overwrite", got)
+	}
+	entries, err := os.ReadDir(filepath.Join(root, "napkin", "acme", "diagram"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected only the object file, found %d entries", len(entries))
+	}
+
+	if err := fsys.Delete(ctx, "napkin", "acme/diagram/0.svg"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := fsys.Download(ctx, "napkin", "acme/diagram/0.svg"); err == nil {
+		t.Error("expected Download of a deleted object to fail")
+	}
+	if err := fsys.Delete(ctx, "napkin", "acme/diagram/0.svg"); err != nil {
+		t.Errorf("Delete of a missing object: %v", err)
+	}
+}
+
+func TestFilesystemList(t *testing.T) {
+	ctx := context.Background()
+	fsys, _ := newTestFilesystem(t, "")
+	for _, key := range []string{"acme/a/0.svg", "acme/a/1.svg", "acme/b/0.png", "globex/a/0.svg"} {
+		if _, err := fsys.UploadWithTags(ctx, "napkin", key, []byte(key), "", nil); err != nil {
+			t.Fatalf("UploadWithTags(%s): %v", key, err)
+		}
+	}
+
+	objects, err := fsys.List(ctx, "napkin", "acme/a/")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	var keys []string
+	for _, obj := range objects {
+		keys = append(keys, obj.Key)
+		if obj.Size != int64(len(obj.Key)) {
+			t.Errorf("%s: size %d, want %d", obj.Key, obj.Size, len(obj.Key))
+		}
+	}
+	sort.Strings(keys)
+	if strings.Join(keys, ",") != "acme/a/0.svg,acme/a/1.svg" {
+		t.Errorf("listed %v", keys)
+	}
+
+	if objects, err := fsys.List(ctx, "missing", ""); err != nil || len(objects) != 0 {
+		t.Errorf("List of a missing bucket = %v, %v; want no objects", objects, err)
+	}
+}
+
+func TestFilesystemRejectsEscapingPaths(t *testing.T) {
+	ctx := context.Background()
+	fsys, root := newTestFilesystem(t, "")
+
+	for _, bucket := range []string{"", ".", "..", "a/b", `a\b`} {
+		if _, err := fsys.UploadWithTags(ctx, bucket, "x.svg", []byte("x"), "", nil); err == nil {
+			t.Errorf("expected bucket %q to be rejected", bucket)
+		}
+	}
+	// Keys are cleaned within the bucket, so ".." can't climb out of it
+	if _, err := fsys.UploadWithTags(ctx, "napkin", "../../outside.svg", []byte("x"), "", nil); err != nil {
+		t.Fatalf("UploadWithTags: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "napkin", "outside.svg")); err != nil {
+		t.Errorf("expected the object inside the bucket: %v", err)
+	}
+	if _, err := fsys.UploadWithTags(ctx, "napkin", "/", []byte("x"), "", nil); err == nil {
+		t.Error("expected an empty key to be rejected")
+	}
+}
+
+func TestFilesystemPublicURL(t *testing.T) {
+	fsys, _ := newTestFilesystem(t, "https://files.example.com/")
+	if got := fsys.ObjectURL("napkin", "/acme/0.svg"); got != "https://files.example.com/napkin/acme/0.svg" {
+		t.Errorf("ObjectURL = %q", got)
+	}
+	if err := fsys.Ping(context.Background(), "napkin"); err != nil {
+		t.Errorf("Ping: %v", err)
+	}
+}
@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"context"
+	"errors"
+)
+
+// ExpireAfterDaysTag is the object tag selecting the expiration rule that
+// EnsureBucket installs, so expiry only applies to the objects of visuals
+// that asked for it rather than to the whole bucket
+const ExpireAfterDaysTag = "expire-after-days"
+
+// Storage is an object store for generated visuals. Objects are grouped in
+// buckets and addressed by slash-separated keys.
+type Storage interface {
+	// EnsureBucket creates the bucket if needed. If expireAfterDays is
+	// positive, objects tagged with ExpireAfterDaysTag set to that value
+	// expire after that many days where the backend supports it.
+	EnsureBucket(ctx context.Context, bucket string, expireAfterDays int) error
+
+	// UploadWithTags stores data under key and returns its download URL.
+	// Backends without object tags ignore them.
+	UploadWithTags(ctx context.Context, bucket, key string, data []byte, contentType string, objectTags map[string]string) (string, error)
+
+	// Download returns the object's data
+	Download(ctx context.Context, bucket, key string) ([]byte, error)
+
+	// Delete removes an object
+	Delete(ctx context.Context, bucket, key string) error
+
+	// List returns the objects under prefix; a missing bucket yields no objects
+	List(ctx context.Context, bucket, prefix string) ([]ObjectInfo, error)
+
+	// ObjectURL returns the download URL for an object
+	ObjectURL(bucket, key string) string
+
+	// Ping checks that the backend is reachable
+	Ping(ctx context.Context, bucket string) error
+}
+
+// ObjectInfo describes a stored object
+type ObjectInfo struct {
+	Key  string
+	Size int64
+}
+
+// TransientError marks a storage failure that may succeed when retried, such
+// as an unreachable endpoint, a server-side error or throttling. Other
+// failures, like invalid tags or keys, fail the same way on every attempt.
+type TransientError struct {
+	Err error
+}
+
+func (e *TransientError) Error() string { return e.Err.Error() }
+func (e *TransientError) Unwrap() error { return e.Err }
+
+// IsTransient reports whether err is or wraps a TransientError
+func IsTransient(err error) bool {
+	var transient *TransientError
+	return errors.As(err, &transient)
+}